@@ -1,10 +1,67 @@
 package dtos
 
+import "time"
+
 type StoreUrlRequest struct {
-	URL       string `json:"url"`
-	Shortcode string `json:"shortcode"`
+	URL          string     `json:"url"`
+	Shortcode    string     `json:"shortcode"`
+	RedirectType int        `json:"redirect_type"` // 301, 302, 307, 308 or empty for the server default
+	ExpiresAt    *time.Time `json:"expires_at"`    // RFC3339
+	MaxClicks    int64      `json:"max_clicks"`
+}
+
+// UpdateUrlRequest is used by PUT, where missing fields are reset, and by
+// PATCH, where they are kept.
+type UpdateUrlRequest struct {
+	Shortcode    string     `param:"shortcode"`
+	URL          *string    `json:"url"`
+	RedirectType *int       `json:"redirect_type"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	MaxClicks    *int64     `json:"max_clicks"`
+}
+
+type DeleteUrlRequest struct {
+	Shortcode string `param:"shortcode"`
+}
+
+type UrlResponse struct {
+	Shortcode    string     `json:"shortcode"`
+	ShortURL     string     `json:"short_url"`
+	URL          string     `json:"url"`
+	RedirectType int        `json:"redirect_type,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	MaxClicks    int64      `json:"max_clicks,omitempty"`
+	Status       string     `json:"status"` // pending, persisted
 }
 
 type GetUrlRequest struct {
 	Shortcode string `param:"shortcode"`
 }
+
+type GetStatsRequest struct {
+	Shortcode   string `param:"shortcode"`
+	From        string `query:"from"` // RFC3339
+	To          string `query:"to"`   // RFC3339
+	Granularity string `query:"granularity"`
+}
+
+type ClickBucketResponse struct {
+	Bucket time.Time `json:"bucket"`
+	Clicks int64     `json:"clicks"`
+}
+
+type ReferrerResponse struct {
+	Referrer string `json:"referrer"`
+	Clicks   int64  `json:"clicks"`
+}
+
+type StatsResponse struct {
+	Shortcode    string                `json:"shortcode"`
+	URL          string                `json:"url"`
+	Accesses     int64                 `json:"accesses"`
+	From         time.Time             `json:"from"`
+	To           time.Time             `json:"to"`
+	Granularity  string                `json:"granularity"`
+	Clicks       []ClickBucketResponse `json:"clicks"`
+	TopReferrers []ReferrerResponse    `json:"top_referrers"`
+}
@@ -1,13 +1,26 @@
 package handlers
 
 import (
+	"fmt"
+	"html"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 	"github.com/rodrigocitadin/url-shortener/internal/errors"
 )
 
+const errorPage = `<!DOCTYPE html>
+<html>
+<head><title>%[1]d %[2]s</title></head>
+<body>
+<h1>%[1]d %[2]s</h1>
+<p>%[3]s</p>
+</body>
+</html>
+`
+
 func HTTPErrorHandler(err error, c echo.Context) {
 	if c.Response().Committed {
 		return
@@ -40,7 +53,22 @@ func HTTPErrorHandler(err error, c echo.Context) {
 		log.Printf("[%s] INFO [%s]: %s", reqID, response.Layer, response.Message)
 	}
 
+	if wantsHTML(c.Request()) {
+		page := fmt.Sprintf(errorPage, code, http.StatusText(code), html.EscapeString(response.Message))
+		if err := c.HTML(code, page); err != nil {
+			log.Printf("[%s] Error sending HTML: %v", reqID, err)
+		}
+		return
+	}
+
 	if err := c.JSON(code, response); err != nil {
 		log.Printf("[%s] Error sending JSON: %v", reqID, err)
 	}
 }
+
+// wantsHTML reports whether the client is a browser asking for a page rather
+// than an API client expecting JSON.
+func wantsHTML(r *http.Request) bool {
+	accept := r.Header.Get(echo.HeaderAccept)
+	return strings.Contains(accept, echo.MIMETextHTML) && !strings.HasPrefix(accept, echo.MIMEApplicationJSON)
+}
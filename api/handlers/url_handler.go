@@ -1,20 +1,30 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/rodrigocitadin/url-shortener/api/dtos"
+	"github.com/rodrigocitadin/url-shortener/internal/entities"
+	"github.com/rodrigocitadin/url-shortener/internal/errors"
+	"github.com/rodrigocitadin/url-shortener/internal/repository"
 	"github.com/rodrigocitadin/url-shortener/internal/services"
 )
 
 type URLHandler interface {
 	GetFullURL(e echo.Context) error
 	StoreFullURL(e echo.Context) error
+	GetStats(e echo.Context) error
+	UpdateURL(e echo.Context) error
+	DeleteURL(e echo.Context) error
 }
 
 type urlHandler struct {
-	URLService services.URLService
+	URLService    services.URLService
+	PublicBaseURL string
 }
 
 func (h *urlHandler) GetFullURL(e echo.Context) error {
@@ -28,7 +38,16 @@ func (h *urlHandler) GetFullURL(e echo.Context) error {
 		return err
 	}
 
-	return e.Redirect(http.StatusMovedPermanently, url.URL)
+	h.URLService.TrackClick(&entities.ClickEvent{
+		Shortcode: req.Shortcode,
+		Timestamp: time.Now().UTC(),
+		Referrer:  e.Request().Referer(),
+		UserAgent: e.Request().UserAgent(),
+		IP:        e.RealIP(),
+	})
+
+	e.Response().Header().Set(echo.HeaderCacheControl, redirectCacheControl(url, time.Now()))
+	return e.Redirect(url.RedirectType, url.URL)
 }
 
 func (h *urlHandler) StoreFullURL(e echo.Context) error {
@@ -38,14 +57,158 @@ func (h *urlHandler) StoreFullURL(e echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	err = h.URLService.Store(req.URL, req.Shortcode)
+	url, status, err := h.URLService.Store(services.StoreInput{
+		URL:          req.URL,
+		Shortcode:    req.Shortcode,
+		RedirectType: req.RedirectType,
+		ExpiresAt:    req.ExpiresAt,
+		MaxClicks:    req.MaxClicks,
+		RequestID:    requestID(e),
+	})
 	if err != nil {
 		return err
 	}
 
-	return e.NoContent(http.StatusCreated)
+	return e.JSON(http.StatusCreated, h.urlResponse(url, status))
 }
 
-func NewURLHandler(urlService services.URLService) URLHandler {
-	return &urlHandler{URLService: urlService}
+// UpdateURL serves both PUT, which replaces the link settings, and PATCH,
+// which only changes the fields present in the body.
+func (h *urlHandler) UpdateURL(e echo.Context) error {
+	var req dtos.UpdateUrlRequest
+	if err := e.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	url, status, err := h.URLService.Update(services.UpdateInput{
+		Shortcode:    req.Shortcode,
+		URL:          req.URL,
+		RedirectType: req.RedirectType,
+		ExpiresAt:    req.ExpiresAt,
+		MaxClicks:    req.MaxClicks,
+		Replace:      e.Request().Method == http.MethodPut,
+		RequestID:    requestID(e),
+	})
+	if err != nil {
+		return err
+	}
+
+	return e.JSON(http.StatusOK, h.urlResponse(url, status))
+}
+
+func (h *urlHandler) DeleteURL(e echo.Context) error {
+	var req dtos.DeleteUrlRequest
+	if err := e.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid shortcode param")
+	}
+
+	status, err := h.URLService.Delete(req.Shortcode, requestID(e))
+	if err != nil {
+		return err
+	}
+
+	if status == repository.WritePending {
+		return e.NoContent(http.StatusAccepted)
+	}
+	return e.NoContent(http.StatusNoContent)
+}
+
+func (h *urlHandler) urlResponse(url *entities.URLEntity, status repository.WriteStatus) dtos.UrlResponse {
+	return dtos.UrlResponse{
+		Shortcode:    url.Shortcode,
+		ShortURL:     h.PublicBaseURL + "/" + url.Shortcode,
+		URL:          url.URL,
+		RedirectType: url.RedirectType,
+		ExpiresAt:    url.ExpiresAt,
+		MaxClicks:    url.MaxClicks,
+		Status:       string(status),
+	}
+}
+
+func (h *urlHandler) GetStats(e echo.Context) error {
+	var req dtos.GetStatsRequest
+	if err := e.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid stats params")
+	}
+
+	query := services.StatsQuery{Granularity: req.Granularity}
+	fields := map[string]string{}
+	if req.From != "" {
+		t, err := time.Parse(time.RFC3339, req.From)
+		if err != nil {
+			fields["from"] = "from must be an RFC3339 timestamp"
+		}
+		query.From = t
+	}
+	if req.To != "" {
+		t, err := time.Parse(time.RFC3339, req.To)
+		if err != nil {
+			fields["to"] = "to must be an RFC3339 timestamp"
+		}
+		query.To = t
+	}
+	if len(fields) > 0 {
+		return errors.NewValidation("handler", fields)
+	}
+
+	stats, err := h.URLService.Stats(req.Shortcode, query)
+	if err != nil {
+		return err
+	}
+
+	clicks := make([]dtos.ClickBucketResponse, len(stats.Series))
+	for i, b := range stats.Series {
+		clicks[i] = dtos.ClickBucketResponse{Bucket: b.Bucket.UTC(), Clicks: b.Clicks}
+	}
+
+	referrers := make([]dtos.ReferrerResponse, len(stats.TopReferrers))
+	for i, r := range stats.TopReferrers {
+		referrers[i] = dtos.ReferrerResponse{Referrer: r.Referrer, Clicks: r.Clicks}
+	}
+
+	return e.JSON(http.StatusOK, dtos.StatsResponse{
+		Shortcode:    stats.Shortcode,
+		URL:          stats.URL,
+		Accesses:     stats.Accesses,
+		From:         stats.From,
+		To:           stats.To,
+		Granularity:  stats.Granularity,
+		Clicks:       clicks,
+		TopReferrers: referrers,
+	})
+}
+
+func NewURLHandler(urlService services.URLService, publicBaseURL string) URLHandler {
+	return &urlHandler{
+		URLService:    urlService,
+		PublicBaseURL: strings.TrimRight(publicBaseURL, "/"),
+	}
+}
+
+func requestID(e echo.Context) string {
+	return e.Response().Header().Get(echo.HeaderXRequestID)
+}
+
+// redirectCacheControl lets browsers keep permanent redirects for a day, or
+// until the link expires, and forces temporary ones back to us, so they can
+// be retargeted and counted. Links with a click limit are never cached: a
+// cached redirect would not count towards it.
+func redirectCacheControl(url *entities.URLEntity, now time.Time) string {
+	if url.MaxClicks > 0 {
+		return "no-store"
+	}
+
+	switch url.RedirectType {
+	case http.StatusMovedPermanently, http.StatusPermanentRedirect:
+		maxAge := 24 * time.Hour
+		if url.ExpiresAt != nil {
+			maxAge = min(maxAge, url.ExpiresAt.Sub(now))
+		}
+		if maxAge < time.Second {
+			return "no-store"
+		}
+		return fmt.Sprintf("public, max-age=%d", int64(maxAge.Seconds()))
+	default:
+		return "no-store"
+	}
 }
@@ -10,9 +10,17 @@ type ServiceChain struct {
 	URLService services.URLService
 }
 
-func Router(e *echo.Echo, serviceChain ServiceChain) {
-	urlHandler := handlers.NewURLHandler(serviceChain.URLService)
+type RouterConfig struct {
+	PublicBaseURL string // used to build the short links returned to clients
+}
+
+func Router(e *echo.Echo, serviceChain ServiceChain, config RouterConfig) {
+	urlHandler := handlers.NewURLHandler(serviceChain.URLService, config.PublicBaseURL)
 
 	e.POST("/", urlHandler.StoreFullURL)
 	e.GET("/:shortcode", urlHandler.GetFullURL)
+	e.GET("/:shortcode/stats", urlHandler.GetStats)
+	e.PUT("/:shortcode", urlHandler.UpdateURL)
+	e.PATCH("/:shortcode", urlHandler.UpdateURL)
+	e.DELETE("/:shortcode", urlHandler.DeleteURL)
 }
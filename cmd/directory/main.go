@@ -0,0 +1,139 @@
+// Command directory lists and changes the shard directory (SHARD_DIRECTORY)
+// and announces every change, so servers and workers switch to it at once
+// instead of after their refresh and the Redis cache expired.
+//
+// A prefix is only pinned or unpinned while no link starts with it: those
+// links would stay on the shard the prefix pointed at before and stop being
+// found. Pin prefixes before handing them out.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rodrigocitadin/url-shortener/internal/repository"
+	"github.com/rodrigocitadin/url-shortener/internal/sharding"
+)
+
+// settleDelay is how long processes get to reload the directory before the
+// prefix is checked for links created during the switch.
+const settleDelay = 2 * time.Second
+
+func main() {
+	var (
+		action  string
+		prefix  string
+		shardID string
+	)
+
+	flag.StringVar(&action, "action", "list", "Action: list, pin, unpin")
+	flag.StringVar(&prefix, "prefix", "", "Shortcode prefix to pin or unpin")
+	flag.StringVar(&shardID, "shard", "", "ID of the shard to pin the prefix to")
+	flag.Parse()
+
+	if action != "list" && action != "pin" && action != "unpin" {
+		log.Fatalf("Error: unknown action %q.", action)
+	}
+	if action != "list" && (prefix == "" || len(prefix) > 20) {
+		log.Fatal("Error: -prefix must have 1 to 20 characters.")
+	}
+
+	cfg, err := sharding.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if !cfg.Directory {
+		log.Fatal("Error: SHARD_DIRECTORY is not enabled.")
+	}
+
+	sm, err := repository.NewShardManager(cfg)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	dirIdx, _ := sm.ShardIndex(cfg.DirectoryShard)
+	directory := repository.NewDatabaseShardDirectoryRepository(sm.GetShard(dirIdx))
+
+	if action == "list" {
+		rows, err := directory.List()
+		if err != nil {
+			log.Fatalf("Failed to read the shard directory: %v", err)
+		}
+		for _, row := range rows {
+			log.Printf("%-20s -> shard %s (since %s)", row.Prefix, row.ShardID, row.CreatedAt.Format(time.RFC3339))
+		}
+		log.Printf("%d prefix(es) pinned.", len(rows))
+		return
+	}
+
+	if action == "pin" && !isCurrentShard(cfg, shardID) {
+		log.Fatalf("Error: %q is not one of SHARD_IDS.", shardID)
+	}
+
+	if total := countLinks(sm, prefix); total > 0 {
+		log.Fatalf("Error: %d link(s) start with %q and would no longer be found; nothing changed.", total, prefix)
+	}
+
+	switch action {
+	case "pin":
+		err = directory.Pin(prefix, shardID)
+	case "unpin":
+		var found bool
+		found, err = directory.Unpin(prefix)
+		if err == nil && !found {
+			log.Printf("%q was not pinned; nothing changed.", prefix)
+			return
+		}
+	}
+	if err != nil {
+		log.Fatalf("Failed to %s %q: %v", action, prefix, err)
+	}
+	log.Printf("%s %q done.", action, prefix)
+
+	redisAddr := os.Getenv("REDIS_URL")
+	if redisAddr == "" {
+		redisAddr = "localhost:6379"
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: redisAddr})
+	defer rdb.Close()
+
+	if err := repository.AnnounceDirectoryChange(context.Background(), rdb); err != nil {
+		log.Printf("Warning: failed to announce the change: %v. Processes pick it up after SHARD_DIRECTORY_REFRESH once the cached directory expired.", err)
+		return
+	}
+
+	time.Sleep(settleDelay)
+	if total := countLinks(sm, prefix); total > 0 {
+		log.Printf("Warning: %d link(s) starting with %q were created while processes switched; check they are on the shard the prefix resolves to now.", total, prefix)
+	}
+}
+
+func isCurrentShard(cfg sharding.Config, id string) bool {
+	for _, current := range cfg.IDs {
+		if current == id {
+			return true
+		}
+	}
+	return false
+}
+
+// countLinks counts the links starting with prefix on every shard, those only
+// the previous topology knows included.
+func countLinks(sm *repository.ShardManager, prefix string) int64 {
+	var total int64
+	for idx := range sm.ShardCount() {
+		n, err := repository.NewDatabaseShardDirectoryRepository(sm.GetShard(idx)).CountLinks(prefix)
+		if err != nil {
+			log.Fatalf("Failed to count links on shard %s: %v", sm.ShardID(idx), err)
+		}
+		if n > 0 {
+			log.Printf("shard %s holds %d link(s) starting with %q", sm.ShardID(idx), n, prefix)
+		}
+		total += n
+	}
+	return total
+}
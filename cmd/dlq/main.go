@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rodrigocitadin/url-shortener/internal/broker"
+	"github.com/rodrigocitadin/url-shortener/internal/messages"
+)
+
+// resetHeaders are dropped on replay so the message starts over with a full
+// retry budget.
+var resetHeaders = []string{
+	"x-retry-count", "x-infra-retries", "x-death",
+	"x-error", "x-error-class", "x-error-shard", "x-error-at",
+}
+
+type filter struct {
+	shortcode string
+	reason    string
+}
+
+type dlqMessage struct {
+	delivery  amqp.Delivery
+	env       *messages.Envelope
+	payload   *messages.URLPayload
+	decodeErr error
+}
+
+func main() {
+	var (
+		action    string
+		amqpURL   string
+		shortcode string
+		reason    string
+		limit     int
+		dryRun    bool
+		verbose   bool
+	)
+
+	flag.StringVar(&action, "action", "list", "Action: list, replay, purge")
+	flag.StringVar(&amqpURL, "amqp", "", "RabbitMQ URL (defaults to RABBITMQ_URL)")
+	flag.StringVar(&shortcode, "shortcode", "", "Only messages for this shortcode")
+	flag.StringVar(&reason, "reason", "", "Only messages whose error, error class or dead-letter reason contains this text")
+	flag.IntVar(&limit, "limit", 1000, "Maximum number of DLQ messages to inspect")
+	flag.BoolVar(&dryRun, "dry-run", false, "If true, only shows what replay or purge would do")
+	flag.BoolVar(&verbose, "v", false, "Print the decoded payload of every message")
+	flag.Parse()
+
+	if action != "list" && action != "replay" && action != "purge" {
+		log.Fatalf("Error: unknown action %q.", action)
+	}
+
+	if amqpURL == "" {
+		amqpURL = os.Getenv("RABBITMQ_URL")
+	}
+	if amqpURL == "" {
+		log.Fatal("Error: No RabbitMQ URL configured.")
+	}
+
+	if dryRun {
+		log.Println("DRY RUN MODE ENABLED: No message will be replayed or purged.")
+	}
+
+	conn, err := amqp.Dial(amqpURL)
+	if err != nil {
+		log.Fatalf("RabbitMQ connection error: %v", err)
+	}
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		log.Fatalf("Failed to open channel: %v", err)
+	}
+	defer ch.Close()
+
+	if err := ch.Confirm(false); err != nil {
+		log.Fatalf("Failed to enable publisher confirms: %v", err)
+	}
+
+	// Messages are held unacked until the end, so every one is seen once;
+	// whatever is not replayed or purged is requeued in place.
+	selected, skipped, err := fetch(ch, filter{shortcode: shortcode, reason: reason}, limit)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", broker.DeadLetterQueue, err)
+	}
+	defer requeue(skipped)
+
+	for _, m := range selected {
+		printMessage(m, verbose)
+	}
+	log.Printf("%d message(s) matched, %d skipped.", len(selected), len(skipped))
+
+	if action == "list" || dryRun {
+		if action != "list" {
+			log.Printf("Would %s %d message(s).", action, len(selected))
+		}
+		requeue(selected)
+		return
+	}
+
+	done := 0
+	for _, m := range selected {
+		var err error
+		switch action {
+		case "replay":
+			err = replay(ch, m.delivery)
+		case "purge":
+			err = m.delivery.Ack(false)
+		}
+
+		if err != nil {
+			log.Printf("Failed to %s message %s: %v", action, m.delivery.MessageId, err)
+			m.delivery.Nack(false, true)
+			continue
+		}
+		done++
+	}
+	log.Printf("Process finished: %d message(s) %sd.", done, action)
+}
+
+func fetch(ch *amqp.Channel, f filter, limit int) (selected, skipped []dlqMessage, err error) {
+	for len(selected)+len(skipped) < limit {
+		d, ok, err := ch.Get(broker.DeadLetterQueue, false)
+		if err != nil {
+			return selected, skipped, err
+		}
+		if !ok {
+			break // queue drained
+		}
+
+		m := dlqMessage{delivery: d}
+		m.env, m.decodeErr = messages.Decode(d.Body)
+		if m.decodeErr == nil {
+			m.payload, m.decodeErr = m.env.URLPayload()
+		}
+
+		if f.matches(m) {
+			selected = append(selected, m)
+		} else {
+			skipped = append(skipped, m)
+		}
+	}
+	return selected, skipped, nil
+}
+
+func (f filter) matches(m dlqMessage) bool {
+	if f.shortcode != "" && (m.payload == nil || m.payload.URL.Shortcode != f.shortcode) {
+		return false
+	}
+	if f.reason != "" && !strings.Contains(strings.Join(reasons(m.delivery), " "), f.reason) {
+		return false
+	}
+	return true
+}
+
+// replay publishes the message back into the main queue and acks the dead
+// copy only once the broker confirmed the new one.
+func replay(ch *amqp.Channel, d amqp.Delivery) error {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	for _, k := range resetHeaders {
+		delete(headers, k)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, "", broker.URLsQueue, false, false, amqp.Publishing{
+		Headers:       headers,
+		ContentType:   d.ContentType,
+		MessageId:     d.MessageId,
+		CorrelationId: d.CorrelationId,
+		Timestamp:     d.Timestamp,
+		Type:          d.Type,
+		Body:          d.Body,
+		DeliveryMode:  amqp.Persistent,
+	})
+	if err != nil {
+		return err
+	}
+
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return fmt.Errorf("broker nacked the replayed message")
+	}
+
+	return d.Ack(false)
+}
+
+func requeue(msgs []dlqMessage) {
+	for _, m := range msgs {
+		m.delivery.Nack(false, true)
+	}
+}
+
+func printMessage(m dlqMessage, verbose bool) {
+	d := m.delivery
+
+	shortcode, operation := "?", "?"
+	if m.env != nil {
+		operation = m.env.Operation
+	}
+	if m.payload != nil {
+		shortcode = m.payload.URL.Shortcode
+	}
+
+	fmt.Printf("id=%s shortcode=%s operation=%s retries=%d deaths=[%s]\n",
+		d.MessageId, shortcode, operation, retryCount(d), strings.Join(deaths(d), "; "))
+
+	if class, ok := d.Headers["x-error-class"]; ok {
+		fmt.Printf("  error class=%v shard=%v at=%v: %v\n",
+			class, headerOr(d, "x-error-shard", "-"), headerOr(d, "x-error-at", "-"), d.Headers["x-error"])
+	}
+
+	if m.decodeErr != nil {
+		fmt.Printf("  undecodable: %v\n", m.decodeErr)
+		return
+	}
+	if verbose {
+		payload, _ := json.MarshalIndent(m.payload, "  ", "  ")
+		fmt.Printf("  %s\n", payload)
+	}
+}
+
+func retryCount(d amqp.Delivery) int64 {
+	switch v := d.Headers["x-retry-count"].(type) {
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	}
+	return 0
+}
+
+// xDeath returns the x-death entries RabbitMQ adds every time the message is
+// dead-lettered, most recent first.
+func xDeath(d amqp.Delivery) []amqp.Table {
+	raw, _ := d.Headers["x-death"].([]any)
+
+	var entries []amqp.Table
+	for _, item := range raw {
+		if t, ok := item.(amqp.Table); ok {
+			entries = append(entries, t)
+		}
+	}
+	return entries
+}
+
+func deaths(d amqp.Delivery) []string {
+	var out []string
+	for _, t := range xDeath(d) {
+		out = append(out, fmt.Sprintf("%v from %v x%v", t["reason"], t["queue"], t["count"]))
+	}
+	return out
+}
+
+// reasons lists what the reason filter matches: the worker's error headers
+// and the x-death reasons.
+func reasons(d amqp.Delivery) []string {
+	var out []string
+	for _, k := range []string{"x-error-class", "x-error"} {
+		if v, ok := d.Headers[k]; ok {
+			out = append(out, fmt.Sprint(v))
+		}
+	}
+	for _, t := range xDeath(d) {
+		out = append(out, fmt.Sprint(t["reason"]))
+	}
+	return out
+}
+
+func headerOr(d amqp.Delivery, name string, fallback any) any {
+	if v, ok := d.Headers[name]; ok {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,218 @@
+// Command reshard moves every link the previous topology (OLD_SHARD_*)
+// placed on a shard the current one (SHARD_*) doesn't. Run the server and
+// worker with both topologies set so reads fall back to the old location
+// meanwhile, and drop the OLD_ variables once it reports nothing left to
+// move.
+//
+// The click rollups of a link move with it; clicks aggregated on the new
+// shard in the meantime are added to the moved ones.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/rodrigocitadin/url-shortener/internal/entities"
+	"github.com/rodrigocitadin/url-shortener/internal/repository"
+	"github.com/rodrigocitadin/url-shortener/internal/sharding"
+	"gorm.io/gorm"
+)
+
+type stats struct {
+	scanned int
+	moved   int
+	failed  int
+}
+
+func main() {
+	var (
+		batch  int
+		only   string
+		dryRun bool
+	)
+
+	flag.IntVar(&batch, "batch", 500, "Rows read and locked per batch on a source shard")
+	flag.StringVar(&only, "shard", "", "Only move rows out of the shard with this id")
+	flag.BoolVar(&dryRun, "dry-run", false, "If true, only counts the rows that would move")
+	flag.Parse()
+
+	cfg, err := sharding.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if cfg.Previous == nil {
+		log.Fatal("Error: OLD_SHARD_DSNS is not set, there is no previous topology to move rows from.")
+	}
+
+	sm, err := repository.NewShardManager(cfg)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	// read straight from the table: a stale cached directory would move rows
+	// to the wrong shard
+	if cfg.Directory {
+		if err := sm.EnableDirectory(context.Background(), cfg.DirectoryShard, nil, time.Minute); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+
+	if dryRun {
+		log.Println("DRY RUN MODE ENABLED: No row will be copied or deleted.")
+	}
+
+	var total stats
+	for _, id := range cfg.Previous.IDs {
+		if only != "" && id != only {
+			continue
+		}
+
+		idx, _ := sm.ShardIndex(id)
+		log.Printf("--- Processing Shard %s ---", id)
+
+		s, err := reshardShard(sm, idx, batch, dryRun)
+		if err != nil {
+			log.Fatalf("Error on Shard %s: %v", id, err)
+		}
+		log.Printf("Shard %s: %d scanned, %d moved, %d failed verification", id, s.scanned, s.moved, s.failed)
+
+		total.scanned += s.scanned
+		total.moved += s.moved
+		total.failed += s.failed
+	}
+
+	log.Printf("Process finished: %d scanned, %d moved, %d failed verification.", total.scanned, total.moved, total.failed)
+	if total.failed > 0 {
+		log.Fatal("Some rows were left in place; run again once the cause is fixed.")
+	}
+}
+
+// reshardShard walks the source shard in id order. Each batch stays locked
+// while its misplaced rows are copied and verified, so dual-read writes to
+// them wait, and is only deleted from the source after verification.
+func reshardShard(sm *repository.ShardManager, src, batch int, dryRun bool) (stats, error) {
+	var (
+		s       stats
+		afterID int64
+	)
+
+	for {
+		done := false
+
+		err := sm.GetShard(src).Transaction(func(tx *gorm.DB) error {
+			rows, err := repository.NewDatabaseReshardRepository(tx).LockBatch(afterID, batch)
+			if err != nil {
+				return err
+			}
+			if len(rows) == 0 {
+				done = true
+				return nil
+			}
+			afterID = rows[len(rows)-1].ID
+			s.scanned += len(rows)
+
+			groups := make(map[int][]*entities.URLEntity)
+			for _, row := range rows {
+				if dest := sm.GetShardIndex(row.Shortcode); dest != src {
+					groups[dest] = append(groups[dest], row)
+				}
+			}
+
+			for dest, group := range groups {
+				if dryRun {
+					log.Printf("would move %d rows to shard %s", len(group), sm.ShardID(dest))
+					s.moved += len(group)
+					continue
+				}
+
+				ok, err := moveGroup(tx, sm.GetShard(dest), group)
+				if err != nil {
+					return err
+				}
+				if ok {
+					s.moved += len(group)
+				} else {
+					log.Printf("verification failed for %d rows bound to shard %s; left in place", len(group), sm.ShardID(dest))
+					s.failed += len(group)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return s, err
+		}
+		if done {
+			return s, nil
+		}
+	}
+}
+
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// moveGroup copies rows to dest, compares count and checksum on both sides
+// and deletes them from the source transaction when they match, together
+// with their click rollups, which are added to dest. A failed group is left
+// where it was.
+//
+// A crash between the dest and the source commit leaves the rows on both
+// shards; the next run finds them identical on dest and finishes the move.
+// Rollups are not idempotent: the same crash counts those clicks twice.
+func moveGroup(src *gorm.DB, dest *gorm.DB, rows []*entities.URLEntity) (bool, error) {
+	shortcodes := make([]string, len(rows))
+	for i, row := range rows {
+		shortcodes[i] = row.Shortcode
+	}
+
+	srcRepo := repository.NewDatabaseReshardRepository(src)
+	want, err := srcRepo.Checksum(shortcodes)
+	if err != nil {
+		return false, err
+	}
+
+	// The copy stays uncommitted until it is verified and the originals are
+	// gone, so nothing else reads or writes it meanwhile; rows the shard
+	// already held are locked for the same reason. A mismatch only rolls
+	// back what this transaction inserted.
+	err = dest.Transaction(func(tx *gorm.DB) error {
+		if _, err := repository.NewDatabaseURLBatchRepository(tx).InsertBatch(rows); err != nil {
+			return err
+		}
+
+		destRepo := repository.NewDatabaseReshardRepository(tx)
+		if err := destRepo.LockShortcodes(shortcodes); err != nil {
+			return err
+		}
+		got, err := destRepo.Checksum(shortcodes)
+		if err != nil {
+			return err
+		}
+		if want != got {
+			return errChecksumMismatch
+		}
+
+		if _, err := srcRepo.DeleteShortcodes(shortcodes); err != nil {
+			return err
+		}
+
+		rollups, err := srcRepo.LockRollups(shortcodes)
+		if err != nil {
+			return err
+		}
+		if len(rollups) > 0 {
+			if err := repository.NewDatabaseClickRollupRepository(tx).Add(rollups); err != nil {
+				return err
+			}
+			if _, err := srcRepo.DeleteRollups(shortcodes); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errChecksumMismatch) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"github.com/rodrigocitadin/url-shortener/internal/repository"
+)
+
+const (
+	accessesFlushBatch = 500
+	// bounds the last flush on shutdown, so a shard that is down can't hold
+	// the worker past its stop grace period
+	accessesFinalFlushTimeout = 5 * time.Second
+)
+
+var accessesFlushed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "worker_accesses_flushed_total",
+	Help: "Total redirect accesses flushed from Redis to the shards",
+}, []string{"shard"})
+
+// runAccessFlusher periodically moves the access counts buffered in Redis to
+// the shard owning each shortcode.
+func runAccessFlusher(ctx context.Context, sm *repository.ShardManager, rdb *redis.Client, interval time.Duration) {
+	counter := repository.NewRedisAccessCounter(rdb)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// last flush so buffered counts don't wait for the next worker
+			finalCtx, cancel := context.WithTimeout(context.Background(), accessesFinalFlushTimeout)
+			flushAccesses(finalCtx, sm, rdb, counter)
+			cancel()
+			return
+		case <-ticker.C:
+			flushAccesses(ctx, sm, rdb, counter)
+		}
+	}
+}
+
+// flushAccesses drains the counters in batches until fewer than a batch are
+// left. It stops early once ctx is done or a shard failed: the restored
+// counts would only be drained again right away.
+func flushAccesses(ctx context.Context, sm *repository.ShardManager, rdb *redis.Client, counter repository.AccessCounter) {
+	for ctx.Err() == nil {
+		counts, err := counter.Drain(accessesFlushBatch)
+		if err != nil {
+			slog.Error("Failed to drain access counters", "error", err)
+			return
+		}
+		if len(counts) == 0 {
+			return
+		}
+
+		byShard := make(map[int]map[string]int64)
+		for code, n := range counts {
+			idx := sm.GetShardIndex(code)
+			if byShard[idx] == nil {
+				byShard[idx] = make(map[string]int64)
+			}
+			byShard[idx][code] = n
+		}
+
+		failed := false
+		for idx, shardCounts := range byShard {
+			shardLabel := "shard-" + sm.ShardID(idx)
+			repo := repository.NewDatabaseAccessRepository(sm.GetShard(idx).WithContext(ctx))
+
+			missing, err := repo.Add(shardCounts)
+			if err != nil {
+				slog.Error("Failed to flush accesses. Restoring counters", "shard", shardLabel, "error", err)
+				if err := counter.Restore(shardCounts); err != nil {
+					slog.Error("Failed to restore access counters", "shard", shardLabel, "error", err)
+				}
+				failed = true
+				continue
+			}
+			if len(missing) > 0 {
+				flushMigrating(ctx, sm, counter, idx, missing, shardCounts)
+			}
+
+			var total int64
+			keys := make([]string, 0, len(shardCounts))
+			for code, n := range shardCounts {
+				total += n
+				keys = append(keys, repository.CacheKey(code))
+			}
+
+			// cached entities carry the old count
+			rdb.Del(ctx, keys...)
+			accessesFlushed.WithLabelValues(shardLabel).Add(float64(total))
+		}
+
+		if failed || len(counts) < accessesFlushBatch {
+			return
+		}
+	}
+}
+
+// flushMigrating adds the counts of links their shard doesn't hold yet to the
+// shard the previous topology placed them on, while cmd/reshard hasn't moved
+// them. A link moved in between is tried on its shard again; counts left
+// over belong to deleted links and are dropped.
+func flushMigrating(ctx context.Context, sm *repository.ShardManager, counter repository.AccessCounter, idx int, missing []string, counts map[string]int64) {
+	byShard := make(map[int]map[string]int64)
+	for _, code := range missing {
+		prevIdx, ok := sm.PreviousShardIndex(code)
+		if !ok {
+			continue
+		}
+		if byShard[prevIdx] == nil {
+			byShard[prevIdx] = make(map[string]int64)
+		}
+		byShard[prevIdx][code] = counts[code]
+	}
+
+	for prevIdx, prevCounts := range byShard {
+		shardLabel := "shard-" + sm.ShardID(prevIdx)
+
+		moved, err := repository.NewDatabaseAccessRepository(sm.GetShard(prevIdx).WithContext(ctx)).Add(prevCounts)
+		if err != nil {
+			slog.Error("Failed to flush accesses to previous shard. Restoring counters", "shard", shardLabel, "error", err)
+			if err := counter.Restore(prevCounts); err != nil {
+				slog.Error("Failed to restore access counters", "shard", shardLabel, "error", err)
+			}
+			continue
+		}
+		if len(moved) == 0 {
+			continue
+		}
+
+		retry := make(map[string]int64, len(moved))
+		for _, code := range moved {
+			retry[code] = prevCounts[code]
+		}
+		if _, err := repository.NewDatabaseAccessRepository(sm.GetShard(idx).WithContext(ctx)).Add(retry); err != nil {
+			slog.Error("Failed to flush accesses of moved links. Restoring counters", "shard", "shard-"+sm.ShardID(idx), "error", err)
+			if err := counter.Restore(retry); err != nil {
+				slog.Error("Failed to restore access counters", "shard", shardLabel, "error", err)
+			}
+		}
+	}
+}
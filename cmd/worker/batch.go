@@ -0,0 +1,208 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rodrigocitadin/url-shortener/internal/entities"
+	"github.com/rodrigocitadin/url-shortener/internal/messages"
+	"github.com/rodrigocitadin/url-shortener/internal/repository"
+)
+
+type batchItem struct {
+	d       amqp.Delivery
+	env     *messages.Envelope
+	payload *messages.URLPayload
+}
+
+// runBatcher collects create messages for up to size deliveries or wait and
+// inserts them with one statement per shard. Any other message flushes the
+// batch first and is then processed on its own, so writes to the same link
+// keep their order.
+func (p *processor) runBatcher(deliveries <-chan amqp.Delivery, size int, wait time.Duration) {
+	var batch []batchItem
+	timer := time.NewTimer(wait)
+	timer.Stop()
+
+	flush := func() {
+		timer.Stop()
+		if len(batch) > 0 {
+			p.processBatch(batch)
+			batch = nil
+		}
+	}
+
+	for {
+		select {
+		case d, ok := <-deliveries:
+			if !ok {
+				flush()
+				return
+			}
+
+			env, err := messages.Decode(d.Body)
+			if err != nil || env.Operation != messages.OperationCreate {
+				flush()
+				p.processMessage(d)
+				continue
+			}
+
+			payload, err := env.URLPayload()
+			if err != nil {
+				flush()
+				p.processMessage(d)
+				continue
+			}
+
+			batch = append(batch, batchItem{d: d, env: env, payload: payload})
+			if len(batch) == 1 {
+				timer.Reset(wait)
+			}
+			if len(batch) >= size {
+				flush()
+			}
+
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+func (p *processor) processBatch(batch []batchItem) {
+	start := time.Now()
+	defer func() {
+		jobDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	groups := make(map[int][]batchItem)
+	for _, item := range batch {
+		code := item.payload.URL.Shortcode
+		shardIdx := p.sm.GetShardIndex(code)
+		shardLabel := "shard-" + p.sm.ShardID(shardIdx)
+
+		if p.alreadyProcessed(item.env.IdempotencyKey, code) {
+			item.d.Ack(false)
+			jobsProcessed.WithLabelValues("duplicate", shardLabel).Inc()
+			continue
+		}
+
+		if p.skipDeleted(item.payload) {
+			p.dropPending(code)
+			item.d.Ack(false)
+			jobsProcessed.WithLabelValues("success", shardLabel).Inc()
+			continue
+		}
+
+		groups[shardIdx] = append(groups[shardIdx], item)
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		done   []amqp.Delivery
+		failed bool
+	)
+	for shardIdx, items := range groups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			succeeded, ok := p.insertGroup(shardIdx, items)
+
+			mu.Lock()
+			done = append(done, succeeded...)
+			failed = failed || !ok
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	ackBatch(done, failed)
+}
+
+// insertGroup writes the creates of one shard with a single statement. It
+// returns the deliveries left to ack, and false if some deliveries of the
+// group were settled another way.
+func (p *processor) insertGroup(shardIdx int, items []batchItem) ([]amqp.Delivery, bool) {
+	shardLabel := "shard-" + p.sm.ShardID(shardIdx)
+
+	urls := make([]*entities.URLEntity, len(items))
+	for i, item := range items {
+		urls[i] = item.payload.URL
+	}
+
+	repo := repository.NewDatabaseURLBatchRepository(p.sm.GetShard(shardIdx))
+	inserted, err := repo.InsertBatch(urls)
+	if err != nil {
+		if isInfrastructureError(err) {
+			for _, item := range items {
+				p.retry(item.d, item.payload.URL.Shortcode, shardIdx, err)
+			}
+			return nil, false
+		}
+
+		// one bad row fails the whole statement: fall back to one insert per
+		// message so the others still go through
+		slog.Error("Batch insert failed. Processing messages one by one", "shard", shardLabel, "size", len(items), "error", err)
+		for _, item := range items {
+			p.processMessage(item.d)
+		}
+		return nil, false
+	}
+
+	// a shortcode repeated within the batch is inserted once; the other
+	// copies are conflicts like any taken shortcode
+	fresh := make(map[string]bool, len(inserted))
+	for _, code := range inserted {
+		fresh[code] = true
+	}
+
+	succeeded := make([]amqp.Delivery, 0, len(items))
+	for _, item := range items {
+		entity := item.payload.URL
+		if fresh[entity.Shortcode] {
+			delete(fresh, entity.Shortcode)
+			p.releasePending(entity.Shortcode)
+			p.markProcessed(item.env.IdempotencyKey)
+		} else {
+			slog.Error("Duplicated key detected", "shortcode", entity.Shortcode)
+			p.releasePending(entity.Shortcode)
+		}
+		succeeded = append(succeeded, item.d)
+		jobsProcessed.WithLabelValues("success", shardLabel).Inc()
+	}
+
+	slog.Info("Batch inserted", "shard", shardLabel, "size", len(items), "inserted", len(inserted))
+	return succeeded, true
+}
+
+// ackBatch acks the whole batch with a single multiple ack when every
+// delivery succeeded on the same channel. Otherwise some tags below the last
+// one may have been settled already, so each delivery is acked on its own.
+func ackBatch(deliveries []amqp.Delivery, failed bool) {
+	if len(deliveries) == 0 {
+		return
+	}
+
+	last := deliveries[0]
+	sameChannel := true
+	for _, d := range deliveries[1:] {
+		if d.Acknowledger != last.Acknowledger {
+			sameChannel = false
+			break
+		}
+		if d.DeliveryTag > last.DeliveryTag {
+			last = d
+		}
+	}
+
+	if !failed && sameChannel {
+		last.Ack(true)
+		return
+	}
+
+	for _, d := range deliveries {
+		d.Ack(false)
+	}
+}
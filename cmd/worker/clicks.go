@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rodrigocitadin/url-shortener/internal/analytics"
+	"github.com/rodrigocitadin/url-shortener/internal/entities"
+	"github.com/rodrigocitadin/url-shortener/internal/repository"
+)
+
+const (
+	clicksQueue      = "clicks_queue"
+	clicksFlushBatch = 500
+	clicksMaxBackoff = time.Minute
+)
+
+var clicksAggregated = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "worker_clicks_aggregated_total",
+	Help: "Total click events written to the hourly rollups",
+}, []string{"shard"})
+
+type rollupKey struct {
+	shortcode string
+	bucket    time.Time
+	referrer  string
+	country   string
+	device    string
+}
+
+func setupClickQueue(ch *amqp.Channel) error {
+	err := ch.ExchangeDeclare(repository.ClicksExchange, "fanout", true, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to declare clicks exchange: %w", err)
+	}
+
+	q, err := ch.QueueDeclare(clicksQueue, true, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to declare clicks queue: %w", err)
+	}
+
+	err = ch.QueueBind(q.Name, "", repository.ClicksExchange, false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to bind clicks queue: %w", err)
+	}
+
+	// Deliveries stay unacked until their shard is flushed, so the prefetch
+	// has to hold more than one batch. While a shard is down its deliveries
+	// fill the window and consumption pauses until it recovers.
+	err = ch.Qos(clicksFlushBatch*2, 0, false)
+	if err != nil {
+		return fmt.Errorf("failed to define clicks Qos: %w", err)
+	}
+
+	return nil
+}
+
+// shardClicks holds the rollups not written yet to one shard and the
+// deliveries they were folded from.
+type shardClicks struct {
+	rollups    map[rollupKey]*entities.ClickRollupEntity
+	deliveries []amqp.Delivery
+}
+
+// runClickAggregator folds click events into hourly rollups in memory and
+// writes them to the owning shards every interval or every clicksFlushBatch
+// events. Each shard's deliveries are acked as soon as its rows are written,
+// so a redelivery never counts a click twice. A shard that fails keeps its
+// rows and deliveries, and flushes back off until it accepts them again.
+func runClickAggregator(ctx context.Context, msgs <-chan amqp.Delivery, sm *repository.ShardManager, geo analytics.GeoIP, interval time.Duration) {
+	pending := make(map[int]*shardClicks)
+	events := 0
+	backoff := time.Duration(0)
+	var retryAt time.Time
+
+	flush := func() {
+		for idx, sc := range pending {
+			shardLabel := "shard-" + sm.ShardID(idx)
+
+			rollups := make([]entities.ClickRollupEntity, 0, len(sc.rollups))
+			var clicks int64
+			for _, r := range sc.rollups {
+				rollups = append(rollups, *r)
+				clicks += r.Clicks
+			}
+
+			repo := repository.NewDatabaseClickRollupRepository(sm.GetShard(idx))
+			if err := repo.Add(rollups); err != nil {
+				backoff = min(max(2*backoff, interval), clicksMaxBackoff)
+				retryAt = time.Now().Add(backoff)
+				slog.Error("Failed to write click rollups", "shard", shardLabel, "retry_in", backoff, "error", err)
+				continue
+			}
+
+			for _, d := range sc.deliveries {
+				d.Ack(false)
+			}
+			events -= len(sc.deliveries)
+			delete(pending, idx)
+			clicksAggregated.WithLabelValues(shardLabel).Add(float64(clicks))
+		}
+
+		if len(pending) == 0 {
+			backoff = 0
+		}
+	}
+
+	// a failing shard would otherwise be retried on every new event
+	flushDue := func() {
+		if time.Now().Before(retryAt) {
+			return
+		}
+		flush()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flush()
+			return
+		case <-ticker.C:
+			flushDue()
+		case d, ok := <-msgs:
+			if !ok {
+				flush()
+				return
+			}
+
+			var event entities.ClickEvent
+			if err := json.Unmarshal(d.Body, &event); err != nil || event.Shortcode == "" {
+				slog.Error("Dropping malformed click event", "error", err)
+				d.Nack(false, false)
+				continue
+			}
+
+			rollup := analytics.Rollup(&event, geo)
+			key := rollupKey{rollup.Shortcode, rollup.Bucket, rollup.Referrer, rollup.Country, rollup.Device}
+			idx := sm.GetShardIndex(rollup.Shortcode)
+			sc, ok := pending[idx]
+			if !ok {
+				sc = &shardClicks{rollups: make(map[rollupKey]*entities.ClickRollupEntity)}
+				pending[idx] = sc
+			}
+			if r, ok := sc.rollups[key]; ok {
+				r.Clicks++
+			} else {
+				sc.rollups[key] = &rollup
+			}
+
+			sc.deliveries = append(sc.deliveries, d)
+			events++
+			if events >= clicksFlushBatch {
+				flushDue()
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rodrigocitadin/url-shortener/internal/broker"
+	"github.com/rodrigocitadin/url-shortener/internal/messages"
+)
+
+// Error classes recorded on dead-lettered messages.
+const (
+	errorClassDecode         = "decode"
+	errorClassValidation     = "validation"
+	errorClassConstraint     = "constraint"
+	errorClassInfrastructure = "infrastructure"
+)
+
+// noShard marks failures that happened before the shard was known.
+const noShard = -1
+
+// errorClass tells why a write could not be applied. Integrity violations
+// (SQLSTATE class 23) are constraints; anything else the shard rejected is
+// treated as invalid data.
+func errorClass(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && strings.HasPrefix(pgErr.Code, "23") {
+		return errorClassConstraint
+	}
+	if isInfrastructureError(err) {
+		return errorClassInfrastructure
+	}
+	return errorClassValidation
+}
+
+// deadLetter publishes a terminal failure to the DLX with the reason attached
+// and acks the original once the broker confirmed the copy. If that publish
+// fails, the message is rejected so the broker still dead-letters it, without
+// the reason.
+//
+// A dead-lettered create was never inserted, so its pending record and any
+// entity cached from it are released: the link must stop redirecting and its
+// shortcode must be free again.
+func (p *processor) deadLetter(d amqp.Delivery, class string, shardIdx int, cause error) {
+	if env, err := messages.Decode(d.Body); err == nil && env.Operation == messages.OperationCreate {
+		if payload, err := env.URLPayload(); err == nil {
+			p.releasePending(payload.URL.Shortcode)
+		}
+	}
+
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers["x-error"] = cause.Error()
+	headers["x-error-class"] = class
+	headers["x-error-at"] = time.Now().UTC()
+	if shardIdx != noShard {
+		headers["x-error-shard"] = p.sm.ShardID(shardIdx)
+	}
+
+	errPub := p.dlq.Publish(broker.DeadLetterExchange, d.RoutingKey, amqp.Publishing{
+		Headers:       headers,
+		ContentType:   d.ContentType,
+		MessageId:     d.MessageId,
+		CorrelationId: d.CorrelationId,
+		Timestamp:     d.Timestamp,
+		Type:          d.Type,
+		Body:          d.Body,
+		DeliveryMode:  amqp.Persistent,
+	})
+	if errPub != nil {
+		slog.Error("Error to publish to the DLX: Giving Nack(false) as fallback", "error", errPub)
+		d.Nack(false, false)
+		return
+	}
+	d.Ack(false)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"github.com/rodrigocitadin/url-shortener/internal/repository"
+)
+
+var linksPurged = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "worker_links_purged_total",
+	Help: "Total expired links purged by the janitor",
+}, []string{"shard", "action"}) // action: delete, archive
+
+type janitorConfig struct {
+	interval time.Duration
+	batch    int
+	grace    time.Duration
+	archive  bool
+}
+
+// runJanitor sweeps every shard on each interval, purging expired links in
+// bounded batches so a large backlog never holds long locks.
+func runJanitor(ctx context.Context, sm *repository.ShardManager, rdb *redis.Client, cfg janitorConfig) {
+	action := "delete"
+	if cfg.archive {
+		action = "archive"
+	}
+
+	slog.Info("Janitor started", "interval", cfg.interval, "batch", cfg.batch, "grace", cfg.grace, "action", action)
+
+	ticker := time.NewTicker(cfg.interval)
+	defer ticker.Stop()
+
+	for {
+		for idx := range sm.ShardCount() {
+			sweepShard(ctx, sm, rdb, idx, cfg, action)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func sweepShard(ctx context.Context, sm *repository.ShardManager, rdb *redis.Client, idx int, cfg janitorConfig, action string) {
+	shardLabel := "shard-" + sm.ShardID(idx)
+	repo := repository.NewDatabasePurgeRepository(sm.GetShard(idx))
+	total := 0
+
+	for ctx.Err() == nil {
+		shortcodes, err := repo.PurgeExpired(cfg.batch, cfg.grace, cfg.archive)
+		if err != nil {
+			slog.Error("Janitor failed to purge shard", "shard", shardLabel, "error", err)
+			return
+		}
+
+		if len(shortcodes) > 0 {
+			keys := make([]string, len(shortcodes))
+			for i, code := range shortcodes {
+				keys[i] = repository.CacheKey(code)
+			}
+			if err := rdb.Del(ctx, keys...).Err(); err != nil {
+				slog.Error("Janitor failed to evict cache keys", "shard", shardLabel, "error", err)
+			}
+
+			total += len(shortcodes)
+			linksPurged.WithLabelValues(shardLabel, action).Add(float64(len(shortcodes)))
+		}
+
+		if len(shortcodes) < cfg.batch {
+			break
+		}
+	}
+
+	if total > 0 {
+		slog.Info("Janitor purged expired links", "shard", shardLabel, "count", total, "action", action)
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -12,6 +11,7 @@ import (
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -20,17 +20,29 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	amqp "github.com/rabbitmq/amqp091-go"
-	"github.com/rodrigocitadin/url-shortener/internal/entities"
+	"github.com/redis/go-redis/v9"
+	"github.com/rodrigocitadin/url-shortener/internal/analytics"
+	"github.com/rodrigocitadin/url-shortener/internal/broker"
+	apperrors "github.com/rodrigocitadin/url-shortener/internal/errors"
+	"github.com/rodrigocitadin/url-shortener/internal/messages"
 	"github.com/rodrigocitadin/url-shortener/internal/repository"
+	"github.com/rodrigocitadin/url-shortener/internal/sharding"
 )
 
-var maxRetries = 3
+var (
+	maxRetries      = 3
+	concurrency     = 10
+	shutdownTimeout = 30 * time.Second
+	batchSize       = 0 // creates are batched when above 1
+	batchWait       = 50 * time.Millisecond
+	retryDelays     = []time.Duration{time.Second, 5 * time.Second, 30 * time.Second, 2 * time.Minute}
+)
 
 var (
 	jobsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "worker_jobs_processed_total",
 		Help: "Total jobs processed by the worker",
-	}, []string{"status", "shard"}) // status: success, error, dlq
+	}, []string{"status", "shard"}) // status: success, duplicate, retry, dlq
 
 	jobDuration = promauto.NewHistogram(prometheus.HistogramOpts{
 		Name:    "worker_job_duration_seconds",
@@ -53,10 +65,14 @@ func isInfrastructureError(err error) bool {
 }
 
 func getRetryCount(d amqp.Delivery) int {
+	return getHeaderCount(d, "x-retry-count")
+}
+
+func getHeaderCount(d amqp.Delivery, name string) int {
 	if d.Headers == nil {
 		return 0
 	}
-	if v, ok := d.Headers["x-retry-count"]; ok {
+	if v, ok := d.Headers[name]; ok {
 		switch val := v.(type) {
 		case int:
 			return val
@@ -69,43 +85,31 @@ func getRetryCount(d amqp.Delivery) int {
 	return 0
 }
 
-func setupQueues(ch *amqp.Channel) (<-chan amqp.Delivery, error) {
-	// DLX (Dead Letter Exchange)
-	err := ch.ExchangeDeclare("urls_dlx", "fanout", true, false, false, false, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to declare exchange: %w", err)
-	}
-
-	// DLQ (Dead Letter Queue)
-	qDlq, err := ch.QueueDeclare("urls_dlq", true, false, false, false, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to declare dlq: %w", err)
-	}
+// retryDelay picks the retry tier for the given attempt; attempts past the
+// last tier keep using it.
+func retryDelay(attempt int) time.Duration {
+	return retryDelays[min(attempt, len(retryDelays)-1)]
+}
 
-	err = ch.QueueBind(qDlq.Name, "", "urls_dlx", false, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to bind dlq: %w", err)
+// setupQueues runs on every channel the URL consumer opens, including the
+// ones reopened after the broker went away.
+func setupQueues(ch *amqp.Channel) error {
+	if err := broker.DeclareURLTopology(ch); err != nil {
+		return err
 	}
 
-	// Main queue with DLX
-	args := amqp.Table{"x-dead-letter-exchange": "urls_dlx"}
-	_, err = ch.QueueDeclare("urls_queue", true, false, false, false, args)
-	if err != nil {
-		return nil, fmt.Errorf("failed to declare main queue: %w", err)
+	if err := broker.DeclareRetryQueues(ch, retryDelays); err != nil {
+		return err
 	}
 
-	// Backpressure
-	err = ch.Qos(1, 0, false)
+	// Backpressure: every worker holds at most one unacked delivery, and the
+	// batcher holds at most one batch
+	err := ch.Qos(max(concurrency, batchSize), 0, false)
 	if err != nil {
-		return nil, fmt.Errorf("failed to define Qos: %w", err)
+		return fmt.Errorf("failed to define Qos: %w", err)
 	}
 
-	msgs, err := ch.Consume("urls_queue", "", false, false, false, false, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to consume: %w", err)
-	}
-
-	return msgs, nil
+	return nil
 }
 
 func main() {
@@ -120,9 +124,52 @@ func main() {
 		}
 	}
 
-	dsnsEnv := os.Getenv("SHARD_DSNS")
-	if dsnsEnv == "" {
-		slog.Error("Shards env not filled", "error", errors.New("Undefined shards env"))
+	if v := os.Getenv("WORKER_CONCURRENCY"); v != "" {
+		num, err := strconv.Atoi(v)
+		if err == nil && num > 0 {
+			concurrency = num
+		}
+	}
+
+	if v := os.Getenv("BATCH_SIZE"); v != "" {
+		num, err := strconv.Atoi(v)
+		if err == nil && num > 0 {
+			batchSize = num
+		}
+	}
+
+	if v := os.Getenv("BATCH_WAIT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil && d > 0 {
+			batchWait = d
+		}
+	}
+
+	if v := os.Getenv("RETRY_DELAYS"); v != "" {
+		var delays []time.Duration
+		for _, item := range strings.Split(v, ",") {
+			d, err := time.ParseDuration(strings.TrimSpace(item))
+			if err != nil || d <= 0 {
+				delays = nil
+				break
+			}
+			delays = append(delays, d)
+		}
+		if len(delays) > 0 {
+			retryDelays = delays
+		}
+	}
+
+	if v := os.Getenv("SHUTDOWN_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			shutdownTimeout = d
+		}
+	}
+
+	shardConfig, err := sharding.ConfigFromEnv()
+	if err != nil {
+		slog.Error("Shards env not filled", "error", err)
 		os.Exit(1)
 	}
 
@@ -132,25 +179,124 @@ func main() {
 		os.Exit(1)
 	}
 
-	sm, err := repository.NewShardManager(strings.Split(dsnsEnv, ","))
+	redisAddr := os.Getenv("REDIS_URL")
+	if redisAddr == "" {
+		slog.Error("Redis env not filled", "error", errors.New("Undefined REDIS_URL env"))
+		os.Exit(1)
+	}
+
+	flushInterval := 10 * time.Second
+	if v := os.Getenv("ACCESSES_FLUSH_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			flushInterval = d
+		}
+	}
+
+	clicksFlushInterval := 5 * time.Second
+	if v := os.Getenv("CLICKS_FLUSH_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			clicksFlushInterval = d
+		}
+	}
+
+	outboxInterval := time.Second
+	if v := os.Getenv("OUTBOX_RELAY_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			outboxInterval = d
+		}
+	}
+
+	outboxRetention := 24 * time.Hour
+	if v := os.Getenv("OUTBOX_RETENTION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			outboxRetention = d
+		}
+	}
+
+	eventsQueue := eventsQueueConfig{
+		maxLength: 100000,
+		ttl:       24 * time.Hour,
+	}
+	eventsQueue.enabled, _ = strconv.ParseBool(os.Getenv("OUTBOX_EVENTS_QUEUE"))
+	if v := os.Getenv("OUTBOX_EVENTS_MAX_LENGTH"); v != "" {
+		num, err := strconv.Atoi(v)
+		if err == nil && num > 0 {
+			eventsQueue.maxLength = num
+		}
+	}
+	if v := os.Getenv("OUTBOX_EVENTS_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil && d > 0 {
+			eventsQueue.ttl = d
+		}
+	}
+
+	janitor := janitorConfig{
+		interval: time.Hour,
+		batch:    1000,
+		grace:    24 * time.Hour,
+		archive:  os.Getenv("JANITOR_MODE") == "archive",
+	}
+	janitorEnabled, _ := strconv.ParseBool(os.Getenv("JANITOR_ENABLED"))
+	if v := os.Getenv("JANITOR_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			janitor.interval = d
+		}
+	}
+	if v := os.Getenv("JANITOR_GRACE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			janitor.grace = d
+		}
+	}
+	if v := os.Getenv("JANITOR_BATCH"); v != "" {
+		num, err := strconv.Atoi(v)
+		if err == nil && num > 0 {
+			janitor.batch = num
+		}
+	}
+
+	geo, err := analytics.LoadGeoIP(os.Getenv("GEOIP_DB"))
+	if err != nil {
+		slog.Error("Failed to load GeoIP database", "error", err)
+		os.Exit(1)
+	}
+
+	sm, err := repository.NewShardManager(shardConfig)
 	if err != nil {
 		slog.Error("Failed to connect to Shards", "error", err)
 		os.Exit(1)
 	}
 
-	conn, err := amqp.Dial(amqpURL)
+	conn, err := broker.Dial(amqpURL, "worker")
 	if err != nil {
 		slog.Error("Failed to connect to RabbitMQ", "error", err)
 		os.Exit(1)
 	}
 	defer conn.Close()
 
-	ch, err := conn.Channel()
-	if err != nil {
-		slog.Error("Failed to create amqp channel", "error", err)
-		os.Exit(1)
+	rdb := redis.NewClient(&redis.Options{Addr: redisAddr})
+	defer rdb.Close()
+
+	if shardConfig.Directory {
+		refresh := 30 * time.Second
+		if v := os.Getenv("SHARD_DIRECTORY_REFRESH"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err == nil && d > 0 {
+				refresh = d
+			}
+		}
+
+		if err := sm.EnableDirectory(context.Background(), shardConfig.DirectoryShard, rdb, refresh); err != nil {
+			slog.Error("Failed to load the shard directory", "error", err)
+			os.Exit(1)
+		}
 	}
-	defer ch.Close()
 
 	go func() {
 		http.Handle("/metrics", promhttp.Handler())
@@ -160,120 +306,384 @@ func main() {
 		}
 	}()
 
-	msgs, err := setupQueues(ch)
+	consumer, err := conn.Consume(broker.URLsQueue, setupQueues)
 	if err != nil {
 		slog.Error("Failed to setup amqp queues", "error", err)
 		os.Exit(1)
 	}
 
-	slog.Info("Worker started. Waiting for messages...", "max_retries", maxRetries)
+	outboxPublisher := repository.NewConfirmingPublisher(outboxConfirmTimeout)
+	_, err = conn.Channel(func(ch *amqp.Channel) error {
+		return setupOutboxChannel(ch, outboxPublisher, eventsQueue)
+	})
+	if err != nil {
+		slog.Error("Failed to setup outbox channel", "error", err)
+		os.Exit(1)
+	}
+
+	dlqPublisher := repository.NewConfirmingPublisher(5 * time.Second)
+	if _, err := conn.Channel(dlqPublisher.Attach); err != nil {
+		slog.Error("Failed to setup dead-letter channel", "error", err)
+		os.Exit(1)
+	}
+
+	clicks, err := conn.Consume(clicksQueue, setupClickQueue)
+	if err != nil {
+		slog.Error("Failed to setup clicks queue", "error", err)
+		os.Exit(1)
+	}
+
+	proc := &processor{
+		sm:         sm,
+		ch:         consumer.Channel,
+		tombstones: repository.NewRedisTombstones(rdb),
+		processed:  repository.NewRedisProcessedMessages(rdb),
+		pending:    repository.NewRedisPendingURLs(rdb),
+		rdb:        rdb,
+		dlq:        dlqPublisher,
+	}
+
+	slog.Info("Worker started. Waiting for messages...", "max_retries", maxRetries, "concurrency", concurrency, "batch_size", batchSize)
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
-	forever := make(chan bool)
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	flusherDone := make(chan struct{})
+	go func() {
+		runAccessFlusher(backgroundCtx, sm, rdb, flushInterval)
+		close(flusherDone)
+	}()
 
+	janitorDone := make(chan struct{})
 	go func() {
-		for d := range msgs {
-			go processMessage(d, sm, ch)
+		if janitorEnabled {
+			runJanitor(backgroundCtx, sm, rdb, janitor)
 		}
-		forever <- true
+		close(janitorDone)
+	}()
+
+	relayDone := make(chan struct{})
+	go func() {
+		runOutboxRelay(backgroundCtx, sm, outboxPublisher, outboxInterval, outboxRetention)
+		close(relayDone)
 	}()
 
+	aggregatorDone := make(chan struct{})
+	go func() {
+		runClickAggregator(backgroundCtx, clicks.Deliveries(), sm, geo, clicksFlushInterval)
+		close(aggregatorDone)
+	}()
+
+	var workers sync.WaitGroup
+	if batchSize > 1 {
+		// a single batcher keeps creates and the writes after them in order;
+		// the shards of a batch are written concurrently
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			proc.runBatcher(consumer.Deliveries(), batchSize, batchWait)
+		}()
+	} else {
+		for range concurrency {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				for d := range consumer.Deliveries() {
+					proc.processMessage(d)
+				}
+			}()
+		}
+	}
+
 	<-stop
 	slog.Info("Worker shutting down...")
+
+	// Stop taking new messages but keep the channel open, so jobs in flight
+	// can still ack. Deliveries closes once the prefetched ones are handed
+	// over; anything left unacked at the deadline is redelivered.
+	if err := consumer.Cancel(); err != nil {
+		slog.Error("Failed to cancel consumer", "error", err)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		slog.Info("In-flight jobs finished")
+	case <-time.After(shutdownTimeout):
+		slog.Warn("Shutdown timeout reached with jobs still in flight", "timeout", shutdownTimeout)
+	}
+
+	stopBackground()
+	<-flusherDone
+	<-aggregatorDone
+	<-janitorDone
+	<-relayDone
+}
+
+type processor struct {
+	sm         *repository.ShardManager
+	ch         *broker.Channel
+	tombstones repository.Tombstones
+	processed  repository.ProcessedMessages
+	pending    repository.PendingURLs
+	rdb        *redis.Client
+	dlq        repository.Publisher // confirm-mode channel for dead-lettered copies
 }
 
-func processMessage(d amqp.Delivery, sm *repository.ShardManager, ch *amqp.Channel) {
+func (p *processor) processMessage(d amqp.Delivery) {
 	start := time.Now()
 	defer func() {
 		duration := time.Since(start).Seconds()
 		jobDuration.Observe(duration)
 	}()
 
-	var entity entities.URLEntity
-	if err := json.Unmarshal(d.Body, &entity); err != nil {
+	env, err := messages.Decode(d.Body)
+	if err != nil {
 		slog.Error("Error decoding JSON: Sending to DLQ.", "error", err)
-		d.Nack(false, false)
+		p.deadLetter(d, errorClassDecode, noShard, err)
+		return
+	}
+
+	apply, ok := operationHandlers[env.Operation]
+	if !ok {
+		slog.Error("Unknown operation: Sending to DLQ.", "operation", env.Operation, "version", env.Version)
+		p.deadLetter(d, errorClassDecode, noShard, fmt.Errorf("unknown operation %q (version %d)", env.Operation, env.Version))
+		return
+	}
+
+	payload, err := env.URLPayload()
+	if err != nil {
+		slog.Error("Error decoding payload: Sending to DLQ.", "operation", env.Operation, "error", err)
+		p.deadLetter(d, errorClassDecode, noShard, err)
 		return
 	}
 
-	shardIdx := sm.GetShardIndex(entity.Shortcode)
-	shardLabel := "shard-" + strconv.Itoa(shardIdx)
+	entity := payload.URL
 
-	db := sm.GetShard(shardIdx)
-	repo := repository.NewDatabaseURLRepository(db)
+	shardIdx := p.sm.GetShardIndex(entity.Shortcode)
+	shardLabel := "shard-" + p.sm.ShardID(shardIdx)
 
-	slog.Info("Processing shortcode", "shortcode", entity.Shortcode, "shard", shardLabel)
+	repo := repository.NewShardURLRepository(p.sm, entity.Shortcode)
 
-	err := repo.Save(&entity)
+	if p.alreadyProcessed(env.IdempotencyKey, entity.Shortcode) {
+		d.Ack(false)
+		jobsProcessed.WithLabelValues("duplicate", shardLabel).Inc()
+		return
+	}
+
+	slog.Info("Processing shortcode",
+		"shortcode", entity.Shortcode,
+		"operation", env.Operation,
+		"version", env.Version,
+		"request_id", env.RequestID,
+		"shard", shardLabel,
+	)
+
+	err = apply(p, payload, repo)
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
 			slog.Error("Duplicated key detected", "shortcode", entity.Shortcode)
+			p.releasePending(entity.Shortcode)
 			d.Ack(false)
 			jobsProcessed.WithLabelValues("success", shardLabel).Inc()
 			return
 		}
 
+		p.retry(d, entity.Shortcode, shardIdx, err)
+	} else {
+		p.markProcessed(env.IdempotencyKey)
+		d.Ack(false)
+		slog.Info("Successfully processed", "shortcode", entity.Shortcode, "shard", shardLabel)
+		jobsProcessed.WithLabelValues("success", shardLabel).Inc()
+	}
+}
+
+// retry parks a failed delivery in the retry tier matching its attempt,
+// from where it returns to the main queue once the tier's TTL expires, or
+// sends it to the DLQ once maxRetries is reached.
+func (p *processor) retry(d amqp.Delivery, shortcode string, shardIdx int, err error) {
+	shardLabel := "shard-" + p.sm.ShardID(shardIdx)
+
+	headers := d.Headers
+	if headers == nil {
+		headers = make(amqp.Table)
+	}
+
+	var delay time.Duration
+	if isInfrastructureError(err) {
+		// a dependency is down, not the message's fault: back off without
+		// using up its retries
+		infraRetries := getHeaderCount(d, "x-infra-retries")
+		headers["x-infra-retries"] = infraRetries + 1
+		delay = retryDelay(infraRetries)
+		slog.Error("Critical Infrastructure Error. Delaying message to avoid overload",
+			"shortcode", shortcode, "error", err, "delay", delay, "shard", shardLabel)
+	} else {
 		currentRetries := getRetryCount(d)
 		if currentRetries >= maxRetries {
 			slog.Warn(
 				"Max Retries was reachedl. Sending it to the DLQ",
-				"shortcode", entity.Shortcode, "retries", currentRetries)
-			d.Nack(false, false)
+				"shortcode", shortcode, "retries", currentRetries)
+			p.deadLetter(d, errorClass(err), shardIdx, err)
 			jobsProcessed.WithLabelValues("dlq", shardLabel).Inc()
 			return
 		}
 
+		headers["x-retry-count"] = currentRetries + 1
+		delay = retryDelay(currentRetries)
 		slog.Error(
 			"Error with message",
-			"shortcode", entity.Shortcode,
+			"shortcode", shortcode,
 			"error", err,
 			"retries", currentRetries+1,
+			"delay", delay,
 			"shard", shardLabel,
 		)
+	}
 
-		if isInfrastructureError(err) {
-			slog.Error("Critical Infrastructure Error. Sleeping to avoid overload", "error", err)
-			time.Sleep(5 * time.Second)
-			d.Nack(false, true)
-			return
-		}
-
-		newHeaders := d.Headers
-		if newHeaders == nil {
-			newHeaders = make(amqp.Table)
-		}
-		newHeaders["x-retry-count"] = currentRetries + 1
-
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-		errPub := ch.PublishWithContext(ctx,
+	errPub := broker.ErrNotConnected
+	if ch := p.ch.Current(); ch != nil {
+		errPub = ch.PublishWithContext(ctx,
 			"",
-			d.RoutingKey,
+			broker.RetryQueue(delay),
 			false,
 			false,
 			amqp.Publishing{
-				Headers:      newHeaders,
-				ContentType:  d.ContentType,
-				Body:         d.Body,
-				DeliveryMode: d.DeliveryMode,
+				Headers:       headers,
+				ContentType:   d.ContentType,
+				MessageId:     d.MessageId,
+				CorrelationId: d.CorrelationId,
+				Timestamp:     d.Timestamp,
+				Type:          d.Type,
+				Body:          d.Body,
+				DeliveryMode:  d.DeliveryMode,
 			},
 		)
+	}
 
-		if errPub != nil {
-			d.Nack(false, true) // Fallback
-			slog.Error("Error to republish retry: Giving Nack(true) as fallback", "error", errPub)
-		} else {
-			d.Ack(false)
-		}
-
-		jobsProcessed.WithLabelValues("retry", shardLabel).Inc()
+	if errPub != nil {
+		d.Nack(false, true) // Fallback
+		slog.Error("Error to republish retry: Giving Nack(true) as fallback", "error", errPub)
 	} else {
 		d.Ack(false)
-		slog.Info("Successfully processed", "shortcode", entity.Shortcode, "shard", shardLabel)
-		jobsProcessed.WithLabelValues("success", shardLabel).Inc()
 	}
+
+	jobsProcessed.WithLabelValues("retry", shardLabel).Inc()
+}
+
+func (p *processor) alreadyProcessed(key, shortcode string) bool {
+	if key == "" {
+		return false
+	}
+
+	seen, err := p.processed.Seen(key)
+	if err != nil {
+		slog.Error("Failed to check idempotency key", "key", key, "error", err)
+	}
+	if seen {
+		slog.Info("Skipping already processed message", "shortcode", shortcode, "key", key)
+	}
+	return seen
+}
+
+func (p *processor) markProcessed(key string) {
+	if key == "" {
+		return
+	}
+	if err := p.processed.Mark(key); err != nil {
+		slog.Error("Failed to record idempotency key", "key", key, "error", err)
+	}
+}
+
+// operationHandlers apply each envelope operation to the repository of the
+// shard owning the shortcode. Operations missing here go straight to the DLQ.
+var operationHandlers = map[string]func(*processor, *messages.URLPayload, repository.URLRepository) error{
+	messages.OperationCreate: (*processor).applyCreate,
+	messages.OperationUpdate: (*processor).applyUpdate,
+	messages.OperationDelete: (*processor).applyDelete,
+}
+
+func (p *processor) applyCreate(payload *messages.URLPayload, repo repository.URLRepository) error {
+	if p.skipDeleted(payload) {
+		p.dropPending(payload.URL.Shortcode)
+		return nil
+	}
+
+	if _, err := repo.Save(payload.URL); err != nil {
+		return err
+	}
+
+	// the insert is visible now: reads go to the shard
+	p.releasePending(payload.URL.Shortcode)
+	return nil
+}
+
+func (p *processor) applyUpdate(payload *messages.URLPayload, repo repository.URLRepository) error {
+	if p.skipDeleted(payload) {
+		return nil
+	}
+	if _, err := repo.Update(payload.URL, payload.Columns); err != nil {
+		return err
+	}
+
+	p.evictCached(payload.URL.Shortcode)
+	return nil
+}
+
+func (p *processor) applyDelete(payload *messages.URLPayload, repo repository.URLRepository) error {
+	p.dropPending(payload.URL.Shortcode)
+
+	_, err := repo.Delete(payload.URL.Shortcode)
+	if err != nil && !apperrors.IsNotFound(err) {
+		return err
+	}
+
+	p.evictCached(payload.URL.Shortcode)
+	return nil
+}
+
+func (p *processor) dropPending(shortcode string) {
+	if err := p.pending.Drop(shortcode); err != nil {
+		slog.Error("Failed to drop pending link", "shortcode", shortcode, "error", err)
+	}
+}
+
+// evictCached drops the cached entity of a link the worker just wrote, which
+// a read may have cached from an older state while the write was queued.
+func (p *processor) evictCached(shortcode string) {
+	if err := p.rdb.Del(context.Background(), repository.CacheKey(shortcode)).Err(); err != nil {
+		slog.Error("Failed to evict cached link", "shortcode", shortcode, "error", err)
+	}
+}
+
+// releasePending forgets the pending record of a settled create and any
+// entity cached from it, which may not be what the shard holds.
+func (p *processor) releasePending(shortcode string) {
+	if err := p.pending.Release(shortcode); err != nil {
+		slog.Error("Failed to release pending link", "shortcode", shortcode, "error", err)
+	}
+}
+
+// skipDeleted reports whether the shortcode was deleted after the write was
+// queued; such writes must not bring the link back.
+func (p *processor) skipDeleted(payload *messages.URLPayload) bool {
+	deleted, err := p.tombstones.Has(payload.URL.Shortcode)
+	if err != nil {
+		slog.Error("Failed to check tombstone", "shortcode", payload.URL.Shortcode, "error", err)
+		return false
+	}
+	if deleted {
+		slog.Info("Skipping write for deleted shortcode", "shortcode", payload.URL.Shortcode)
+	}
+	return deleted
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rodrigocitadin/url-shortener/internal/repository"
+	"gorm.io/gorm"
+)
+
+const (
+	outboxBatch          = 100
+	outboxPruneBatch     = 1000
+	outboxConfirmTimeout = 5 * time.Second
+)
+
+var outboxRelayed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "worker_outbox_relayed_total",
+	Help: "Total outbox messages published and confirmed by the broker",
+}, []string{"shard"})
+
+// eventsQueueConfig describes the optional EventsQueue. Once full, or once a
+// message is older than ttl, the oldest events are dropped.
+type eventsQueueConfig struct {
+	enabled   bool
+	maxLength int
+	ttl       time.Duration
+}
+
+// setupOutboxChannel declares the events exchange and, if enabled, the
+// bounded events queue. Events are published as mandatory: while no queue
+// is bound to the exchange they come back unroutable and their rows stay
+// unsent.
+func setupOutboxChannel(ch *amqp.Channel, publisher *repository.ConfirmingPublisher, events eventsQueueConfig) error {
+	err := ch.ExchangeDeclare(repository.EventsExchange, "fanout", true, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to declare events exchange: %w", err)
+	}
+
+	if events.enabled {
+		args := amqp.Table{
+			"x-max-length":  int64(events.maxLength),
+			"x-message-ttl": events.ttl.Milliseconds(),
+		}
+		q, err := ch.QueueDeclare(repository.EventsQueue, true, false, false, false, args)
+		if err != nil {
+			return fmt.Errorf("failed to declare events queue: %w", err)
+		}
+
+		if err := ch.QueueBind(q.Name, "", repository.EventsExchange, false, nil); err != nil {
+			return fmt.Errorf("failed to bind events queue: %w", err)
+		}
+	}
+
+	return publisher.Attach(ch)
+}
+
+// runOutboxRelay publishes the unsent outbox rows of every shard and marks
+// them sent once the broker confirms it routed them to a queue. Rows are
+// locked with SKIP LOCKED, so several workers can relay at the same time.
+// Sent rows are deleted once they are older than retention.
+func runOutboxRelay(ctx context.Context, sm *repository.ShardManager, publisher repository.Publisher, interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for idx := range sm.ShardCount() {
+			shardLabel := "shard-" + sm.ShardID(idx)
+
+			for ctx.Err() == nil {
+				sent, err := relayShard(sm.GetShard(idx), publisher)
+				outboxRelayed.WithLabelValues(shardLabel).Add(float64(sent))
+				if err != nil {
+					slog.Error("Outbox relay failed", "shard", shardLabel, "error", err)
+					break
+				}
+				if sent < outboxBatch {
+					break
+				}
+			}
+
+			repo := repository.NewDatabaseOutboxRepository(sm.GetShard(idx))
+			if _, err := repo.DeleteSent(time.Now().Add(-retention), outboxPruneBatch); err != nil {
+				slog.Error("Failed to prune sent outbox rows", "shard", shardLabel, "error", err)
+			}
+		}
+	}
+}
+
+// relayShard publishes one batch in order and stops at the first message the
+// broker doesn't take; the rows sent before it are still marked.
+func relayShard(db *gorm.DB, publisher repository.Publisher) (int, error) {
+	sent := 0
+	var publishErr error
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		repo := repository.NewDatabaseOutboxRepository(tx)
+
+		rows, err := repo.FetchUnsent(outboxBatch)
+		if err != nil {
+			return err
+		}
+
+		var acked []int64
+		for _, row := range rows {
+			publishErr = publisher.Publish(repository.EventsExchange, "", amqp.Publishing{
+				DeliveryMode: amqp.Persistent,
+				ContentType:  "application/json",
+				Type:         row.Operation,
+				Body:         []byte(row.Payload),
+			})
+			if publishErr != nil {
+				break
+			}
+			acked = append(acked, row.ID)
+		}
+
+		if err := repo.MarkSent(acked); err != nil {
+			return err
+		}
+		sent = len(acked)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return sent, publishErr
+}
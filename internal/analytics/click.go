@@ -0,0 +1,66 @@
+package analytics
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rodrigocitadin/url-shortener/internal/entities"
+)
+
+const (
+	DeviceBot     = "bot"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceDesktop = "desktop"
+	DeviceUnknown = "unknown"
+)
+
+// DeviceClass buckets a user agent into a coarse device class. It is a
+// heuristic on purpose; exact models are not worth a parser dependency.
+func DeviceClass(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	switch {
+	case ua == "":
+		return DeviceUnknown
+	case strings.Contains(ua, "bot"), strings.Contains(ua, "crawler"),
+		strings.Contains(ua, "spider"), strings.Contains(ua, "curl"),
+		strings.Contains(ua, "wget"):
+		return DeviceBot
+	case strings.Contains(ua, "ipad"), strings.Contains(ua, "tablet"),
+		strings.Contains(ua, "android") && !strings.Contains(ua, "mobile"):
+		return DeviceTablet
+	case strings.Contains(ua, "mobi"), strings.Contains(ua, "iphone"),
+		strings.Contains(ua, "android"):
+		return DeviceMobile
+	default:
+		return DeviceDesktop
+	}
+}
+
+// ReferrerHost keeps only the host of the referrer so rollups stay small.
+func ReferrerHost(referrer string) string {
+	u, err := url.Parse(referrer)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// Rollup turns a click into the hourly rollup row it contributes to.
+func Rollup(event *entities.ClickEvent, geo GeoIP) entities.ClickRollupEntity {
+	ts := event.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+
+	return entities.ClickRollupEntity{
+		Shortcode: event.Shortcode,
+		Bucket:    ts.UTC().Truncate(time.Hour),
+		Referrer:  ReferrerHost(event.Referrer),
+		Country:   geo.Country(event.IP),
+		Device:    DeviceClass(event.UserAgent),
+		Clicks:    1,
+	}
+}
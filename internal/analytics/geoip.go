@@ -0,0 +1,225 @@
+package analytics
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const UnknownCountry = ""
+
+// GeoIP resolves client IPs to ISO country codes from a local database file.
+type GeoIP interface {
+	Country(ip string) string
+}
+
+type ipRange struct {
+	start   net.IP
+	end     net.IP
+	country string
+}
+
+type csvGeoIP struct {
+	ranges []ipRange
+}
+
+type noopGeoIP struct{}
+
+// LoadGeoIP reads the GeoLite2 (or GeoIP2) Country database in its CSV
+// edition. path is the directory the MaxMind archive extracts to, holding
+// the *-Blocks-IPv4.csv, *-Blocks-IPv6.csv and *-Locations-en.csv files. A
+// single CSV file with "network,country_iso_code" rows is read as is. An
+// empty path disables lookups.
+func LoadGeoIP(path string) (GeoIP, error) {
+	if path == "" {
+		return noopGeoIP{}, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("open geoip db: %w", err)
+	}
+
+	var ranges []ipRange
+	if info.IsDir() {
+		ranges, err = loadMaxMindCSV(path)
+	} else {
+		ranges, err = readBlocks(path, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("geoip db %s has no networks", path)
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return bytes.Compare(ranges[i].start, ranges[j].start) < 0
+	})
+
+	return &csvGeoIP{ranges: ranges}, nil
+}
+
+func loadMaxMindCSV(dir string) ([]ipRange, error) {
+	locationFiles, _ := filepath.Glob(filepath.Join(dir, "*-Locations-en.csv"))
+	if len(locationFiles) == 0 {
+		return nil, fmt.Errorf("no *-Locations-en.csv file in %s", dir)
+	}
+
+	countries, err := readLocations(locationFiles[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var ranges []ipRange
+	for _, pattern := range []string{"*-Blocks-IPv4.csv", "*-Blocks-IPv6.csv"} {
+		files, _ := filepath.Glob(filepath.Join(dir, pattern))
+		for _, file := range files {
+			blocks, err := readBlocks(file, countries)
+			if err != nil {
+				return nil, err
+			}
+			ranges = append(ranges, blocks...)
+		}
+	}
+	return ranges, nil
+}
+
+// readLocations maps geoname_id to country_iso_code.
+func readLocations(path string) (map[string]string, error) {
+	countries := make(map[string]string)
+	err := readCSV(path, func(header map[string]int, record []string) {
+		id := field(record, header, "geoname_id", -1)
+		country := field(record, header, "country_iso_code", -1)
+		if id != "" && country != "" {
+			countries[id] = country
+		}
+	})
+	return countries, err
+}
+
+// readBlocks reads the networks of a blocks file. With countries set, rows
+// carry geoname ids, the represented country first and the registered one
+// as a fallback; otherwise the second column is the country code itself.
+func readBlocks(path string, countries map[string]string) ([]ipRange, error) {
+	var ranges []ipRange
+	err := readCSV(path, func(header map[string]int, record []string) {
+		_, network, err := net.ParseCIDR(field(record, header, "network", 0))
+		if err != nil {
+			return // header or malformed row
+		}
+
+		var country string
+		if countries == nil {
+			country = field(record, header, "country_iso_code", 1)
+		} else {
+			country = countries[field(record, header, "geoname_id", -1)]
+			if country == "" {
+				country = countries[field(record, header, "registered_country_geoname_id", -1)]
+			}
+		}
+
+		country = strings.ToUpper(country)
+		if len(country) != 2 {
+			return
+		}
+		ranges = append(ranges, newIPRange(network, country))
+	})
+	return ranges, err
+}
+
+// readCSV calls fn for every row after the first. A first row that names
+// the columns is passed along as header; otherwise it is read as a row too.
+func readCSV(path string, fn func(header map[string]int, record []string)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open geoip db: %w", err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+
+	var header map[string]int
+	for first := true; ; first = false {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read geoip db %s: %w", path, err)
+		}
+
+		if first && len(record) > 0 && strings.TrimSpace(record[0]) != "" {
+			if _, _, err := net.ParseCIDR(strings.TrimSpace(record[0])); err != nil {
+				header = make(map[string]int, len(record))
+				for i, name := range record {
+					header[strings.TrimSpace(name)] = i
+				}
+				continue
+			}
+		}
+		fn(header, record)
+	}
+}
+
+// field returns the named column of record, or the column at fallback when
+// the file has no header naming it; a negative fallback means none.
+func field(record []string, header map[string]int, name string, fallback int) string {
+	i, ok := header[name]
+	if !ok {
+		i = fallback
+	}
+	if i < 0 || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+func newIPRange(network *net.IPNet, country string) ipRange {
+	start := network.IP.To16()
+	end := make(net.IP, len(start))
+	mask := net.IP(network.Mask)
+	if len(mask) == net.IPv4len {
+		mask = append(net.IP(bytes.Repeat([]byte{0xff}, 12)), mask...)
+	}
+	for i := range start {
+		end[i] = start[i] | ^mask[i]
+	}
+
+	return ipRange{
+		start:   start,
+		end:     end,
+		country: country,
+	}
+}
+
+func (g *csvGeoIP) Country(ip string) string {
+	parsed := net.ParseIP(ip).To16()
+	if parsed == nil {
+		return UnknownCountry
+	}
+
+	i := sort.Search(len(g.ranges), func(i int) bool {
+		return bytes.Compare(g.ranges[i].start, parsed) > 0
+	})
+	if i == 0 {
+		return UnknownCountry
+	}
+
+	r := g.ranges[i-1]
+	if bytes.Compare(parsed, r.end) > 0 {
+		return UnknownCountry
+	}
+	return r.country
+}
+
+func (noopGeoIP) Country(string) string {
+	return UnknownCountry
+}
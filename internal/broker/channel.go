@@ -0,0 +1,98 @@
+package broker
+
+import (
+	"log"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Channel is an AMQP channel that is replaced by a new one, set up again,
+// when the broker closes it or the connection is recovered.
+type Channel struct {
+	conn  *Connection
+	setup func(*amqp.Channel) error
+
+	reopenMu sync.Mutex // serializes reopen between the channel and connection watchers
+
+	mu sync.RWMutex
+	ch *amqp.Channel
+}
+
+// Current returns the channel in use, or nil while it is being reopened.
+// Callers must not keep it around: ask again for every operation.
+func (c *Channel) Current() *amqp.Channel {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.ch == nil || c.ch.IsClosed() {
+		return nil
+	}
+	return c.ch
+}
+
+func (c *Channel) open() error {
+	conn := c.conn.current()
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	raw, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	closes := raw.NotifyClose(make(chan *amqp.Error, 1))
+
+	if err := c.setup(raw); err != nil {
+		raw.Close()
+		return err
+	}
+
+	c.mu.Lock()
+	c.ch = raw
+	c.mu.Unlock()
+
+	go c.watch(closes)
+	return nil
+}
+
+// reopen opens a new channel unless another watcher already did.
+func (c *Channel) reopen() error {
+	c.reopenMu.Lock()
+	defer c.reopenMu.Unlock()
+
+	if c.Current() != nil {
+		return nil
+	}
+	return c.open()
+}
+
+// watch reopens the channel when the broker closes it on its own, e.g. after
+// a channel exception. When the whole connection went down the connection
+// supervisor reopens it instead.
+func (c *Channel) watch(closes <-chan *amqp.Error) {
+	amqpErr, ok := <-closes
+	if !ok || c.conn.current() == nil {
+		return
+	}
+
+	log.Printf("AMQP channel on %s closed: %v. Reopening.", c.conn.name, amqpErr)
+	c.recover()
+}
+
+// recover retries reopen with backoff while the connection stays up.
+func (c *Channel) recover() {
+	backoff := minBackoff
+	for c.conn.current() != nil {
+		err := c.reopen()
+		if err == nil {
+			return
+		}
+
+		log.Printf("Failed to reopen AMQP channel on %s: %v. Retrying in %s.", c.conn.name, err, backoff)
+		if !c.conn.sleep(backoff) {
+			return
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
@@ -0,0 +1,215 @@
+package broker
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 30 * time.Second
+)
+
+var (
+	ErrNotConnected = errors.New("amqp connection is not available")
+
+	connectionUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "amqp_connection_up",
+		Help: "1 while the AMQP connection is open, 0 while it is being recovered",
+	}, []string{"connection"})
+
+	reconnects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "amqp_reconnects_total",
+		Help: "Total successful AMQP reconnections",
+	}, []string{"connection"})
+)
+
+// Connection is an AMQP connection that dials again whenever the broker
+// drops it, then reopens every channel and consumer created from it.
+type Connection struct {
+	url  string
+	name string
+
+	mu        sync.Mutex
+	conn      *amqp.Connection
+	channels  []*Channel
+	consumers []*Consumer
+	closed    bool
+	done      chan struct{}
+}
+
+// Dial opens the first connection synchronously, so a broker that is down at
+// startup is still reported to the caller. name labels the metrics.
+func Dial(url string, name string) (*Connection, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Connection{
+		url:  url,
+		name: name,
+		conn: conn,
+		done: make(chan struct{}),
+	}
+	connectionUp.WithLabelValues(name).Set(1)
+
+	go c.supervise(conn)
+	return c, nil
+}
+
+// Channel opens a channel and runs setup on it. setup runs again on every
+// channel reopened after a failure, so it must be safe to repeat.
+func (c *Connection) Channel(setup func(*amqp.Channel) error) (*Channel, error) {
+	ch := &Channel{conn: c, setup: setup}
+	if err := ch.open(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.channels = append(c.channels, ch)
+	c.mu.Unlock()
+	return ch, nil
+}
+
+// Consume opens a channel, runs setup on it and consumes queue. Deliveries
+// from every channel the consumer goes through arrive on the same Go channel.
+func (c *Connection) Consume(queue string, setup func(*amqp.Channel) error) (*Consumer, error) {
+	cs := &Consumer{
+		queue:      queue,
+		deliveries: make(chan amqp.Delivery),
+	}
+
+	ch, err := c.Channel(func(raw *amqp.Channel) error {
+		if err := setup(raw); err != nil {
+			return err
+		}
+		return cs.start(raw)
+	})
+	if err != nil {
+		return nil, err
+	}
+	cs.Channel = ch
+
+	c.mu.Lock()
+	c.consumers = append(c.consumers, cs)
+	c.mu.Unlock()
+	return cs, nil
+}
+
+// Close stops the recovery and closes the connection. Consumers close their
+// deliveries once what was already received is handed over.
+func (c *Connection) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	conn := c.conn
+	consumers := c.consumers
+	c.mu.Unlock()
+
+	for _, cs := range consumers {
+		cs.stop()
+	}
+	connectionUp.WithLabelValues(c.name).Set(0)
+	return conn.Close()
+}
+
+// current returns the open connection, or nil while it is being recovered.
+func (c *Connection) current() *amqp.Connection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || c.conn == nil || c.conn.IsClosed() {
+		return nil
+	}
+	return c.conn
+}
+
+func (c *Connection) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *Connection) supervise(conn *amqp.Connection) {
+	for {
+		select {
+		case <-c.done:
+			return
+		case amqpErr := <-conn.NotifyClose(make(chan *amqp.Error, 1)):
+			if c.isClosed() {
+				return
+			}
+			connectionUp.WithLabelValues(c.name).Set(0)
+			log.Printf("AMQP connection %s lost: %v. Reconnecting.", c.name, amqpErr)
+		}
+
+		conn = c.redial()
+		if conn == nil {
+			return
+		}
+		connectionUp.WithLabelValues(c.name).Set(1)
+		reconnects.WithLabelValues(c.name).Inc()
+		log.Printf("AMQP connection %s recovered", c.name)
+
+		c.mu.Lock()
+		channels := append([]*Channel(nil), c.channels...)
+		c.mu.Unlock()
+
+		for _, ch := range channels {
+			if err := ch.reopen(); err != nil {
+				log.Printf("Failed to reopen AMQP channel on %s: %v", c.name, err)
+				go ch.recover()
+			}
+		}
+	}
+}
+
+// redial retries with exponential backoff until it connects or the
+// connection is closed, in which case it returns nil.
+func (c *Connection) redial() *amqp.Connection {
+	backoff := minBackoff
+	for {
+		conn, err := amqp.Dial(c.url)
+		if err == nil {
+			c.mu.Lock()
+			if c.closed {
+				c.mu.Unlock()
+				conn.Close()
+				return nil
+			}
+			c.conn = conn
+			c.mu.Unlock()
+			return conn
+		}
+
+		log.Printf("AMQP reconnect on %s failed: %v. Retrying in %s.", c.name, err, backoff)
+		if !c.sleep(backoff) {
+			return nil
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// sleep waits for d and reports false if the connection was closed meanwhile.
+func (c *Connection) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-c.done:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
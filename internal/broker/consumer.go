@@ -0,0 +1,94 @@
+package broker
+
+import (
+	"strconv"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Consumer keeps consuming a queue across reopened channels. Acks and nacks
+// of deliveries from a channel that was lost fail; the broker redelivers
+// them on the new one.
+type Consumer struct {
+	*Channel
+
+	queue      string
+	deliveries chan amqp.Delivery
+
+	mu       sync.Mutex
+	tag      string
+	active   int // forwarders still draining a channel
+	stopped  bool
+	sequence int
+}
+
+// Deliveries is closed once the consumer is cancelled, or its connection
+// closed, and every delivery already received was handed over.
+func (cs *Consumer) Deliveries() <-chan amqp.Delivery {
+	return cs.deliveries
+}
+
+// Cancel stops consuming but keeps the channel open, so deliveries already
+// handed over can still be acked.
+func (cs *Consumer) Cancel() error {
+	cs.mu.Lock()
+	tag := cs.tag
+	cs.mu.Unlock()
+
+	cs.stop()
+
+	ch := cs.Current()
+	if ch == nil {
+		return ErrNotConnected
+	}
+	return ch.Cancel(tag, false)
+}
+
+func (cs *Consumer) start(ch *amqp.Channel) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if cs.stopped {
+		return nil
+	}
+
+	cs.sequence++
+	tag := cs.queue + "-" + strconv.Itoa(cs.sequence)
+	msgs, err := ch.Consume(cs.queue, tag, false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	cs.tag = tag
+	cs.active++
+	go cs.forward(msgs)
+	return nil
+}
+
+func (cs *Consumer) forward(msgs <-chan amqp.Delivery) {
+	for d := range msgs {
+		cs.deliveries <- d
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.active--
+	if cs.stopped && cs.active == 0 {
+		close(cs.deliveries)
+	}
+}
+
+func (cs *Consumer) stop() {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if cs.stopped {
+		return
+	}
+	cs.stopped = true
+	if cs.active == 0 {
+		close(cs.deliveries)
+	}
+}
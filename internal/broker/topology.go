@@ -0,0 +1,69 @@
+package broker
+
+import (
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	URLsQueue          = "urls_queue"
+	DeadLetterExchange = "urls_dlx"
+	DeadLetterQueue    = "urls_dlq"
+)
+
+// DeclareURLTopology declares the queue URL writes go through and the dead
+// letter exchange and queue behind it. Declarations are idempotent, so it
+// runs on every channel that is (re)opened.
+func DeclareURLTopology(ch *amqp.Channel) error {
+	// DLX (Dead Letter Exchange)
+	err := ch.ExchangeDeclare(DeadLetterExchange, "fanout", true, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to declare exchange: %w", err)
+	}
+
+	// DLQ (Dead Letter Queue)
+	qDlq, err := ch.QueueDeclare(DeadLetterQueue, true, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to declare dlq: %w", err)
+	}
+
+	err = ch.QueueBind(qDlq.Name, "", DeadLetterExchange, false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to bind dlq: %w", err)
+	}
+
+	// Main queue with DLX
+	args := amqp.Table{"x-dead-letter-exchange": DeadLetterExchange}
+	_, err = ch.QueueDeclare(URLsQueue, true, false, false, false, args)
+	if err != nil {
+		return fmt.Errorf("failed to declare main queue: %w", err)
+	}
+
+	return nil
+}
+
+// RetryQueue names the retry tier holding messages for delay.
+func RetryQueue(delay time.Duration) string {
+	return URLsQueue + "_retry_" + delay.String()
+}
+
+// DeclareRetryQueues declares one queue per delay. Messages sit in a tier
+// until its TTL expires and are then dead-lettered back into URLsQueue, so
+// retries wait without holding a consumer. Queues are named after their
+// delay because a queue's TTL can't change once declared.
+func DeclareRetryQueues(ch *amqp.Channel, delays []time.Duration) error {
+	for _, delay := range delays {
+		args := amqp.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": URLsQueue,
+		}
+		_, err := ch.QueueDeclare(RetryQueue(delay), true, false, false, false, args)
+		if err != nil {
+			return fmt.Errorf("failed to declare retry queue for %s: %w", delay, err)
+		}
+	}
+	return nil
+}
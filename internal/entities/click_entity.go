@@ -0,0 +1,26 @@
+package entities
+
+import "time"
+
+// ClickEvent is published on every redirect. It is kept raw on purpose;
+// the worker enriches it (country, device) while aggregating.
+type ClickEvent struct {
+	Shortcode string    `json:"shortcode"`
+	Timestamp time.Time `json:"timestamp"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+}
+
+type ClickRollupEntity struct {
+	Shortcode string
+	Bucket    time.Time
+	Referrer  string
+	Country   string
+	Device    string
+	Clicks    int64
+}
+
+func (ClickRollupEntity) TableName() string {
+	return "url_clicks_hourly"
+}
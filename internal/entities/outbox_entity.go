@@ -0,0 +1,16 @@
+package entities
+
+import "time"
+
+type OutboxEntity struct {
+	ID        int64
+	Shortcode string
+	Operation string
+	Payload   string
+	CreatedAt time.Time
+	SentAt    *time.Time
+}
+
+func (OutboxEntity) TableName() string {
+	return "outbox"
+}
@@ -0,0 +1,15 @@
+package entities
+
+import "time"
+
+// ShardDirectoryEntity pins every shortcode starting with Prefix to the
+// shard with ShardID, whatever the hash placement says.
+type ShardDirectoryEntity struct {
+	Prefix    string
+	ShardID   string
+	CreatedAt time.Time
+}
+
+func (ShardDirectoryEntity) TableName() string {
+	return "shard_directory"
+}
@@ -1,10 +1,25 @@
 package entities
 
+import "time"
+
 type URLEntity struct {
-	ID        int64
-	Shortcode string
-	URL       string
-	Accesses  int64
+	ID           int64
+	Shortcode    string
+	URL          string
+	Accesses     int64
+	RedirectType int // 0 uses the server default
+	ExpiresAt    *time.Time
+	MaxClicks    int64      // 0 means unlimited
+	ExhaustedAt  *time.Time // when accesses reached MaxClicks, set by the access flush
+}
+
+// Expired reports whether the link stopped working, either because its
+// expiry time passed or because accesses reached its click limit.
+func (e *URLEntity) Expired(now time.Time, accesses int64) bool {
+	if e.ExpiresAt != nil && !now.Before(*e.ExpiresAt) {
+		return true
+	}
+	return e.MaxClicks > 0 && accesses >= e.MaxClicks
 }
 
 func (URLEntity) TableName() string {
@@ -1,15 +1,17 @@
 package errors
 
 import (
+	stderrors "errors"
 	"fmt"
 	"net/http"
 )
 
 type Error struct {
-	Message  string `json:"message"`
-	Layer    string `json:"layer,omitempty"`
-	Code     int    `json:"-"`
-	Internal error  `json:"-"`
+	Message  string            `json:"message"`
+	Layer    string            `json:"layer,omitempty"`
+	Fields   map[string]string `json:"fields,omitempty"`
+	Code     int               `json:"-"`
+	Internal error             `json:"-"`
 }
 
 func (e *Error) Error() string {
@@ -32,10 +34,29 @@ func NewBadRequest(message, layer string, err error) *Error {
 	return New(http.StatusBadRequest, message, layer, err)
 }
 
+func NewValidation(layer string, fields map[string]string) *Error {
+	err := New(http.StatusBadRequest, "Validation failed", layer, nil)
+	err.Fields = fields
+	return err
+}
+
 func NewNotFound(message, layer string, err error) *Error {
 	return New(http.StatusNotFound, message, layer, err)
 }
 
+func NewConflict(message, layer string, err error) *Error {
+	return New(http.StatusConflict, message, layer, err)
+}
+
+func NewGone(message, layer string, err error) *Error {
+	return New(http.StatusGone, message, layer, err)
+}
+
 func NewInternal(layer string, err error) *Error {
 	return New(http.StatusInternalServerError, "Internal Server Error", layer, err)
 }
+
+func IsNotFound(err error) bool {
+	var appErr *Error
+	return stderrors.As(err, &appErr) && appErr.Code == http.StatusNotFound
+}
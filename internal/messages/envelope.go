@@ -0,0 +1,114 @@
+package messages
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rodrigocitadin/url-shortener/internal/entities"
+)
+
+// SchemaVersion is the envelope version written by this build. Version 0 is
+// reserved for messages published before the envelope existed.
+const SchemaVersion = 1
+
+const (
+	OperationCreate = "create"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+)
+
+// Envelope wraps every message published to urls_queue. The worker dispatches
+// on Operation and decodes Payload according to it, so new operations can be
+// added without touching the ones already in flight.
+type Envelope struct {
+	Version        int             `json:"version"`
+	Operation      string          `json:"operation"`
+	IdempotencyKey string          `json:"idempotency_key"`
+	RequestID      string          `json:"request_id,omitempty"`
+	Timestamp      time.Time       `json:"timestamp"`
+	Payload        json.RawMessage `json:"payload"`
+}
+
+// URLPayload is the payload of create, update and delete operations. Columns
+// is only set on updates; deletes only carry the shortcode.
+type URLPayload struct {
+	URL     *entities.URLEntity `json:"url"`
+	Columns []string            `json:"columns,omitempty"`
+}
+
+func New(operation, requestID string, payload any) (*Envelope, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{
+		Version:        SchemaVersion,
+		Operation:      operation,
+		IdempotencyKey: key,
+		RequestID:      requestID,
+		Timestamp:      time.Now().UTC(),
+		Payload:        data,
+	}, nil
+}
+
+// Decode reads an envelope. Messages from before the envelope, either a bare
+// URLEntity or an {operation, url, columns} object, are upgraded to a
+// version 0 envelope so the worker handles them like any other.
+func Decode(body []byte) (*Envelope, error) {
+	var probe struct {
+		Version   int    `json:"version"`
+		Operation string `json:"operation"`
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case probe.Version > 0:
+		var env Envelope
+		if err := json.Unmarshal(body, &env); err != nil {
+			return nil, err
+		}
+		if env.Version > SchemaVersion {
+			return nil, fmt.Errorf("unsupported envelope version %d", env.Version)
+		}
+		return &env, nil
+	case probe.Operation != "":
+		// {operation, url, columns}: the payload fields sit at the top level
+		return &Envelope{Operation: probe.Operation, Payload: body}, nil
+	default:
+		payload, err := json.Marshal(map[string]json.RawMessage{"url": body})
+		if err != nil {
+			return nil, err
+		}
+		return &Envelope{Operation: OperationCreate, Payload: payload}, nil
+	}
+}
+
+func (e *Envelope) URLPayload() (*URLPayload, error) {
+	var payload URLPayload
+	if err := json.Unmarshal(e.Payload, &payload); err != nil {
+		return nil, err
+	}
+	if payload.URL == nil || payload.URL.Shortcode == "" {
+		return nil, fmt.Errorf("%s message without shortcode", e.Operation)
+	}
+	return &payload, nil
+}
+
+func newIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,121 @@
+package messages
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/rodrigocitadin/url-shortener/internal/entities"
+)
+
+func TestDecodeCurrentEnvelope(t *testing.T) {
+	env, err := New(OperationUpdate, "req-1", &URLPayload{
+		URL:     &entities.URLEntity{Shortcode: "abc", URL: "https://example.com"},
+		Columns: []string{"url"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Decode(body)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if got.Version != SchemaVersion || got.Operation != OperationUpdate || got.RequestID != "req-1" || got.IdempotencyKey != env.IdempotencyKey {
+		t.Errorf("Decode = %+v, want the published envelope", got)
+	}
+
+	payload, err := got.URLPayload()
+	if err != nil {
+		t.Fatalf("URLPayload failed: %v", err)
+	}
+	if payload.URL.Shortcode != "abc" || !reflect.DeepEqual(payload.Columns, []string{"url"}) {
+		t.Errorf("URLPayload = %+v", payload)
+	}
+}
+
+func TestDecodeLegacyMessages(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		operation string
+		columns   []string
+	}{
+		{
+			name:      "bare entity",
+			body:      `{"ID":0,"Shortcode":"abc","URL":"https://example.com","Accesses":0}`,
+			operation: OperationCreate,
+		},
+		{
+			name:      "operation object",
+			body:      `{"operation":"update","url":{"Shortcode":"abc","URL":"https://example.com"},"columns":["url"]}`,
+			operation: OperationUpdate,
+			columns:   []string{"url"},
+		},
+		{
+			name:      "operation object delete",
+			body:      `{"operation":"delete","url":{"Shortcode":"abc"}}`,
+			operation: OperationDelete,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := Decode([]byte(tt.body))
+			if err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			if env.Version != 0 {
+				t.Errorf("Version = %d, want 0 for a legacy message", env.Version)
+			}
+			if env.Operation != tt.operation {
+				t.Errorf("Operation = %q, want %q", env.Operation, tt.operation)
+			}
+
+			payload, err := env.URLPayload()
+			if err != nil {
+				t.Fatalf("URLPayload failed: %v", err)
+			}
+			if payload.URL.Shortcode != "abc" {
+				t.Errorf("Shortcode = %q, want abc", payload.URL.Shortcode)
+			}
+			if !reflect.DeepEqual(payload.Columns, tt.columns) {
+				t.Errorf("Columns = %v, want %v", payload.Columns, tt.columns)
+			}
+		})
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"not json", `not json`},
+		{"future version", `{"version":99,"operation":"create","payload":{}}`},
+		{"array", `[1,2,3]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode([]byte(tt.body)); err == nil {
+				t.Errorf("Decode(%s) succeeded, want an error", tt.body)
+			}
+		})
+	}
+}
+
+func TestURLPayloadRequiresShortcode(t *testing.T) {
+	env, err := Decode([]byte(`{"URL":"https://example.com"}`))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if _, err := env.URLPayload(); err == nil {
+		t.Error("URLPayload succeeded for a message without shortcode")
+	}
+}
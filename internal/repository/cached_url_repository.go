@@ -3,29 +3,72 @@ package repository
 import (
 	"context"
 	"encoding/json"
+	"log"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/rodrigocitadin/url-shortener/internal/entities"
+	"github.com/rodrigocitadin/url-shortener/internal/errors"
 )
 
 type cachedURLRepository struct {
-	next  URLRepository
-	redis *redis.Client
+	next       URLRepository
+	redis      *redis.Client
+	tombstones Tombstones
+	// afterCommit, when set, defers the Redis side effects of writes until
+	// the surrounding transaction committed.
+	afterCommit func(func())
 }
 
 const (
 	cacheTTL = 1
 	cacheKey = "url:"
+
+	// shortcodes that were looked up and not found are remembered for a
+	// short while so repeated misses don't reach the database
+	missTTL = 30
+	missKey = "url-miss:"
 )
 
+// CacheKey is the Redis key holding the cached entity for shortCode.
+func CacheKey(shortCode string) string {
+	return cacheKey + shortCode
+}
+
 func NewCachedURLRepository(next URLRepository, redisClient *redis.Client) URLRepository {
 	return &cachedURLRepository{
-		next:  next,
-		redis: redisClient,
+		next:       next,
+		redis:      redisClient,
+		tombstones: NewRedisTombstones(redisClient),
+	}
+}
+
+// newTxCachedURLRepository caches a repository bound to a transaction: reads
+// go through the cache as usual, writes only touch it once afterCommit runs
+// what they queued.
+func newTxCachedURLRepository(next URLRepository, redisClient *redis.Client, afterCommit func(func())) URLRepository {
+	return &cachedURLRepository{
+		next:        next,
+		redis:       redisClient,
+		tombstones:  NewRedisTombstones(redisClient),
+		afterCommit: afterCommit,
 	}
 }
 
+// effect runs fn right away, or queues it for after the commit, in which
+// case its error can only be logged.
+func (r *cachedURLRepository) effect(fn func() error) error {
+	if r.afterCommit == nil {
+		return fn()
+	}
+	r.afterCommit(func() {
+		if err := fn(); err != nil {
+			log.Printf("Redis error after commit: %v", err)
+		}
+	})
+	return nil
+}
+
 func (r *cachedURLRepository) Find(shortCode string) (*entities.URLEntity, error) {
 	ctx := context.Background() // remove this later
 	key := cacheKey + shortCode
@@ -38,29 +81,131 @@ func (r *cachedURLRepository) Find(shortCode string) (*entities.URLEntity, error
 		}
 	}
 
+	if n, err := r.redis.Exists(ctx, missKey+shortCode).Result(); err == nil && n > 0 {
+		return nil, errors.NewNotFound("shortcode not found", "repository", nil)
+	}
+
 	entity, err := r.next.Find(shortCode)
+	if errors.IsNotFound(err) {
+		r.redis.Set(ctx, missKey+shortCode, 1, missTTL*time.Second)
+		return nil, err
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	if data, err := json.Marshal(entity); err == nil {
-		r.redis.Set(ctx, key, data, cacheTTL*time.Hour)
+		if ttl := entityTTL(entity); ttl > 0 {
+			r.redis.Set(ctx, key, data, ttl)
+		}
 	}
 
 	return entity, nil
 }
 
-func (r *cachedURLRepository) Save(urlEntity *entities.URLEntity) error {
-	if err := r.next.Save(urlEntity); err != nil {
-		return err
+func (r *cachedURLRepository) Save(urlEntity *entities.URLEntity) (WriteStatus, error) {
+	// a new link with the shortcode of a deleted one is a fresh intent
+	err := r.effect(func() error {
+		return r.tombstones.Clear(urlEntity.Shortcode)
+	})
+	if err != nil {
+		return "", err
 	}
 
-	ctx := context.Background() // remove this later
-	key := cacheKey + urlEntity.Shortcode
+	status, err := r.next.Save(urlEntity)
+	if err != nil {
+		return "", err
+	}
+
+	r.effect(func() error {
+		ctx := context.Background() // remove this later
+		key := cacheKey + urlEntity.Shortcode
+
+		// queued links are served from their pending record until the worker
+		// inserts them and promotes it to this cache entry
+		if status == WritePersisted {
+			if data, err := json.Marshal(urlEntity); err == nil {
+				if ttl := entityTTL(urlEntity); ttl > 0 {
+					r.redis.Set(ctx, key, data, ttl)
+				}
+			}
+		}
+		return r.redis.Del(ctx, missKey+urlEntity.Shortcode).Err()
+	})
+
+	return status, nil
+}
 
-	if data, err := json.Marshal(urlEntity); err == nil {
-		r.redis.Set(ctx, key, data, cacheTTL*time.Hour)
+// Update caches the new entity instead of just dropping the old one: with a
+// queued update, a read in between would otherwise cache the stale row again.
+func (r *cachedURLRepository) Update(urlEntity *entities.URLEntity, columns []string) (WriteStatus, error) {
+	status, err := r.next.Update(urlEntity, columns)
+	if err != nil {
+		return "", err
 	}
 
-	return nil
+	r.effect(func() error {
+		ctx := context.Background()
+		key := cacheKey + urlEntity.Shortcode
+
+		data, err := json.Marshal(urlEntity)
+		if ttl := entityTTL(urlEntity); err == nil && ttl > 0 {
+			return r.redis.Set(ctx, key, data, ttl).Err()
+		}
+		return r.redis.Del(ctx, key).Err()
+	})
+
+	return status, nil
+}
+
+// Delete marks the shortcode as deleted before anything else so the worker
+// skips writes for it that are still queued, and keeps answering not found
+// while a queued delete drains.
+func (r *cachedURLRepository) Delete(shortCode string) (WriteStatus, error) {
+	err := r.effect(func() error {
+		return r.tombstones.Mark(shortCode)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	status, err := r.next.Delete(shortCode)
+	if err != nil {
+		if r.afterCommit == nil {
+			r.tombstones.Clear(shortCode)
+		}
+		return "", err
+	}
+
+	r.effect(func() error {
+		ctx := context.Background()
+		r.redis.Del(ctx, cacheKey+shortCode)
+		return r.redis.Set(ctx, missKey+shortCode, 1, tombstoneTTL*time.Hour).Err()
+	})
+
+	return status, nil
+}
+
+func (r *cachedURLRepository) Exists(shortCode string) (bool, error) {
+	ctx := context.Background()
+	key := cacheKey + shortCode
+
+	if n, err := r.redis.Exists(ctx, key).Result(); err == nil && n > 0 {
+		return true, nil
+	}
+	if n, err := r.redis.Exists(ctx, missKey+shortCode).Result(); err == nil && n > 0 {
+		return false, nil
+	}
+
+	return r.next.Exists(shortCode)
+}
+
+// entityTTL caps the cache TTL at the link expiry so a cached entry never
+// outlives the link itself.
+func entityTTL(entity *entities.URLEntity) time.Duration {
+	ttl := cacheTTL * time.Hour
+	if entity.ExpiresAt != nil {
+		ttl = min(ttl, time.Until(*entity.ExpiresAt))
+	}
+	return ttl
 }
@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"github.com/rodrigocitadin/url-shortener/internal/entities"
+	"gorm.io/gorm"
+)
+
+// exhaustedAtSQL recomputes urls.exhausted_at for a row whose accesses grow
+// by the bound amount: it keeps the first time the click limit was reached,
+// so the janitor's grace period runs from there, and clears it when a raised
+// limit brought the link back.
+const exhaustedAtSQL = `CASE WHEN max_clicks > 0 AND accesses + ? >= max_clicks THEN coalesce(exhausted_at, now()) END`
+
+type AccessRepository interface {
+	// Add adds counts to the accesses of each link and returns the
+	// shortcodes this shard has no link for.
+	Add(counts map[string]int64) ([]string, error)
+}
+
+type accessRepository struct {
+	db *gorm.DB
+}
+
+func NewDatabaseAccessRepository(db *gorm.DB) AccessRepository {
+	return &accessRepository{db: db}
+}
+
+func (r *accessRepository) Add(counts map[string]int64) ([]string, error) {
+	var missing []string
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		for code, n := range counts {
+			res := tx.Model(&entities.URLEntity{}).
+				Where("shortcode = ?", code).
+				UpdateColumns(map[string]any{
+					"accesses":     gorm.Expr("accesses + ?", n),
+					"exhausted_at": gorm.Expr(exhaustedAtSQL, n),
+				})
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				missing = append(missing, code)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return missing, nil
+}
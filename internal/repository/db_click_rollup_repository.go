@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/rodrigocitadin/url-shortener/internal/entities"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ClickBucket struct {
+	Bucket time.Time
+	Clicks int64
+}
+
+type ReferrerCount struct {
+	Referrer string
+	Clicks   int64
+}
+
+type ClickRollupRepository interface {
+	Add(rollups []entities.ClickRollupEntity) error
+	Series(shortCode string, from, to time.Time, granularity string) ([]ClickBucket, error)
+	// TopReferrers returns at most limit referrers, or all of them when
+	// limit is 0.
+	TopReferrers(shortCode string, from, to time.Time, limit int) ([]ReferrerCount, error)
+}
+
+type clickRollupRepository struct {
+	db *gorm.DB
+}
+
+func NewDatabaseClickRollupRepository(db *gorm.DB) ClickRollupRepository {
+	return &clickRollupRepository{db: db}
+}
+
+func (r *clickRollupRepository) Add(rollups []entities.ClickRollupEntity) error {
+	if len(rollups) == 0 {
+		return nil
+	}
+
+	return r.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{
+			{Name: "shortcode"}, {Name: "bucket"}, {Name: "referrer"}, {Name: "country"}, {Name: "device"},
+		},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"clicks": gorm.Expr("url_clicks_hourly.clicks + excluded.clicks"),
+		}),
+	}).Create(&rollups).Error
+}
+
+// Series sums the hourly rollups in [from, to) into buckets of granularity,
+// which must be a date_trunc unit such as "hour" or "day".
+func (r *clickRollupRepository) Series(shortCode string, from, to time.Time, granularity string) ([]ClickBucket, error) {
+	var buckets []ClickBucket
+	err := r.db.Model(&entities.ClickRollupEntity{}).
+		Select("date_trunc(?, bucket) AS bucket, SUM(clicks) AS clicks", granularity).
+		Where("shortcode = ? AND bucket >= ? AND bucket < ?", shortCode, from, to).
+		Group("1").
+		Order("1").
+		Scan(&buckets).Error
+	return buckets, err
+}
+
+func (r *clickRollupRepository) TopReferrers(shortCode string, from, to time.Time, limit int) ([]ReferrerCount, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+
+	var referrers []ReferrerCount
+	err := r.db.Model(&entities.ClickRollupEntity{}).
+		Select("referrer, SUM(clicks) AS clicks").
+		Where("shortcode = ? AND bucket >= ? AND bucket < ?", shortCode, from, to).
+		Group("referrer").
+		Order("clicks DESC").
+		Limit(limit).
+		Scan(&referrers).Error
+	return referrers, err
+}
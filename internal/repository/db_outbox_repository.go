@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/rodrigocitadin/url-shortener/internal/entities"
+	"github.com/rodrigocitadin/url-shortener/internal/messages"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// EventsExchange receives the messages relayed from the outbox, for consumers
+// that need every committed link change; each binds its own queue. The worker
+// can declare EventsQueue, a bounded one, for consumers that don't.
+const (
+	EventsExchange = "urls_events"
+	EventsQueue    = "urls_events_queue"
+)
+
+// OutboxRepository stores messages in the same shard transaction as the
+// write they describe; the worker relay publishes them afterwards.
+type OutboxRepository interface {
+	Add(env *messages.Envelope, shortCode string) error
+	// FetchUnsent locks up to limit unsent rows, oldest first. It must run
+	// inside a transaction so concurrent relays skip the locked rows.
+	FetchUnsent(limit int) ([]entities.OutboxEntity, error)
+	MarkSent(ids []int64) error
+	// DeleteSent removes up to limit rows sent before the given time.
+	DeleteSent(before time.Time, limit int) (int64, error)
+}
+
+type outboxRepository struct {
+	db *gorm.DB
+}
+
+func NewDatabaseOutboxRepository(db *gorm.DB) OutboxRepository {
+	return &outboxRepository{db: db}
+}
+
+func (r *outboxRepository) Add(env *messages.Envelope, shortCode string) error {
+	payload, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	return r.db.Create(&entities.OutboxEntity{
+		Shortcode: shortCode,
+		Operation: env.Operation,
+		Payload:   string(payload),
+	}).Error
+}
+
+func (r *outboxRepository) FetchUnsent(limit int) ([]entities.OutboxEntity, error) {
+	var rows []entities.OutboxEntity
+	err := r.db.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("sent_at IS NULL").
+		Order("id").
+		Limit(limit).
+		Find(&rows).Error
+	return rows, err
+}
+
+func (r *outboxRepository) MarkSent(ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.db.Model(&entities.OutboxEntity{}).
+		Where("id IN ?", ids).
+		Update("sent_at", time.Now()).Error
+}
+
+func (r *outboxRepository) DeleteSent(before time.Time, limit int) (int64, error) {
+	res := r.db.Exec(`DELETE FROM outbox WHERE id IN (
+		SELECT id FROM outbox WHERE sent_at < ? ORDER BY id LIMIT ?
+	)`, before, limit)
+	return res.RowsAffected, res.Error
+}
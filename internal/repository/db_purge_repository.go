@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// expiredCondition matches links past their expiry or exhausted by their
+// click limit, in both cases for longer than a grace period, so they keep
+// answering 410 for a while.
+const expiredCondition = `(expires_at IS NOT NULL AND expires_at <= @before)
+    OR (max_clicks > 0 AND accesses >= max_clicks AND exhausted_at <= @before)`
+
+const deleteExpiredSQL = `
+WITH doomed AS (
+    SELECT id FROM urls WHERE ` + expiredCondition + `
+    ORDER BY id LIMIT @limit FOR UPDATE SKIP LOCKED
+)
+DELETE FROM urls u USING doomed WHERE u.id = doomed.id
+RETURNING u.shortcode`
+
+const archiveExpiredSQL = `
+WITH doomed AS (
+    SELECT id FROM urls WHERE ` + expiredCondition + `
+    ORDER BY id LIMIT @limit FOR UPDATE SKIP LOCKED
+), moved AS (
+    DELETE FROM urls u USING doomed WHERE u.id = doomed.id
+    RETURNING u.id, u.shortcode, u.url, u.accesses, u.redirect_type, u.expires_at, u.max_clicks, u.exhausted_at
+)
+INSERT INTO urls_archive (id, shortcode, url, accesses, redirect_type, expires_at, max_clicks, exhausted_at)
+SELECT id, shortcode, url, accesses, redirect_type, expires_at, max_clicks, exhausted_at FROM moved
+RETURNING shortcode`
+
+type PurgeRepository interface {
+	// PurgeExpired removes up to limit expired links and returns their
+	// shortcodes. With archive set, rows are moved to urls_archive instead.
+	PurgeExpired(limit int, grace time.Duration, archive bool) ([]string, error)
+}
+
+type purgeRepository struct {
+	db *gorm.DB
+}
+
+func NewDatabasePurgeRepository(db *gorm.DB) PurgeRepository {
+	return &purgeRepository{db: db}
+}
+
+func (r *purgeRepository) PurgeExpired(limit int, grace time.Duration, archive bool) ([]string, error) {
+	query := deleteExpiredSQL
+	if archive {
+		query = archiveExpiredSQL
+	}
+
+	var shortcodes []string
+	err := r.db.Raw(query, map[string]any{
+		"before": time.Now().Add(-grace),
+		"limit":  limit,
+	}).Scan(&shortcodes).Error
+	return shortcodes, err
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"github.com/rodrigocitadin/url-shortener/internal/entities"
+	"gorm.io/gorm"
+)
+
+// urlChecksumSQL digests every column that moves with a link. Expiry is
+// compared as an epoch so the session time zone of each shard doesn't
+// matter; ids are left out, every shard assigns its own.
+const urlChecksumSQL = `
+SELECT count(*) AS count,
+       coalesce(md5(string_agg(concat_ws('|',
+           shortcode, url, accesses, redirect_type,
+           coalesce(extract(epoch FROM expires_at)::text, ''), max_clicks,
+           coalesce(extract(epoch FROM exhausted_at)::text, '')
+       ), ',' ORDER BY shortcode)), '') AS checksum
+FROM urls WHERE shortcode IN ?`
+
+type URLChecksum struct {
+	Count    int64
+	Checksum string
+}
+
+type ReshardRepository interface {
+	// LockBatch locks up to limit links with an id above afterID, in id
+	// order, until the surrounding transaction ends.
+	LockBatch(afterID int64, limit int) ([]*entities.URLEntity, error)
+	// LockShortcodes locks the given links until the surrounding transaction
+	// ends.
+	LockShortcodes(shortcodes []string) error
+	Checksum(shortcodes []string) (URLChecksum, error)
+	DeleteShortcodes(shortcodes []string) (int64, error)
+	// LockRollups locks the click rollups of the given links until the
+	// surrounding transaction ends.
+	LockRollups(shortcodes []string) ([]entities.ClickRollupEntity, error)
+	DeleteRollups(shortcodes []string) (int64, error)
+}
+
+type reshardRepository struct {
+	db *gorm.DB
+}
+
+func NewDatabaseReshardRepository(db *gorm.DB) ReshardRepository {
+	return &reshardRepository{db: db}
+}
+
+func (r *reshardRepository) LockBatch(afterID int64, limit int) ([]*entities.URLEntity, error) {
+	var urls []*entities.URLEntity
+	err := r.db.Raw(`SELECT * FROM urls WHERE id > ? ORDER BY id LIMIT ? FOR UPDATE`, afterID, limit).
+		Scan(&urls).Error
+	return urls, err
+}
+
+func (r *reshardRepository) LockShortcodes(shortcodes []string) error {
+	var ids []int64
+	return r.db.Raw(`SELECT id FROM urls WHERE shortcode IN ? FOR UPDATE`, shortcodes).
+		Scan(&ids).Error
+}
+
+func (r *reshardRepository) Checksum(shortcodes []string) (URLChecksum, error) {
+	var sum URLChecksum
+	err := r.db.Raw(urlChecksumSQL, shortcodes).Scan(&sum).Error
+	return sum, err
+}
+
+func (r *reshardRepository) DeleteShortcodes(shortcodes []string) (int64, error) {
+	res := r.db.Where("shortcode IN ?", shortcodes).Delete(&entities.URLEntity{})
+	return res.RowsAffected, res.Error
+}
+
+func (r *reshardRepository) LockRollups(shortcodes []string) ([]entities.ClickRollupEntity, error) {
+	var rollups []entities.ClickRollupEntity
+	err := r.db.Raw(`SELECT * FROM url_clicks_hourly WHERE shortcode IN ? FOR UPDATE`, shortcodes).
+		Scan(&rollups).Error
+	return rollups, err
+}
+
+func (r *reshardRepository) DeleteRollups(shortcodes []string) (int64, error) {
+	res := r.db.Where("shortcode IN ?", shortcodes).Delete(&entities.ClickRollupEntity{})
+	return res.RowsAffected, res.Error
+}
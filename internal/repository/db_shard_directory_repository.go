@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"github.com/rodrigocitadin/url-shortener/internal/entities"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ShardDirectoryRepository interface {
+	List() ([]entities.ShardDirectoryEntity, error)
+	// Pin points prefix at shardID, replacing any entry it had.
+	Pin(prefix, shardID string) error
+	// Unpin removes the entry of prefix and reports whether there was one.
+	Unpin(prefix string) (bool, error)
+	// CountLinks counts the links of this shard whose shortcode starts with
+	// prefix, wherever the directory itself lives.
+	CountLinks(prefix string) (int64, error)
+}
+
+type shardDirectoryRepository struct {
+	db *gorm.DB
+}
+
+func NewDatabaseShardDirectoryRepository(db *gorm.DB) ShardDirectoryRepository {
+	return &shardDirectoryRepository{db: db}
+}
+
+func (r *shardDirectoryRepository) List() ([]entities.ShardDirectoryEntity, error) {
+	var rows []entities.ShardDirectoryEntity
+	err := r.db.Order("prefix").Find(&rows).Error
+	return rows, err
+}
+
+func (r *shardDirectoryRepository) Pin(prefix, shardID string) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "prefix"}},
+		DoUpdates: clause.AssignmentColumns([]string{"shard_id"}),
+	}).Omit("created_at").Create(&entities.ShardDirectoryEntity{Prefix: prefix, ShardID: shardID}).Error
+}
+
+func (r *shardDirectoryRepository) Unpin(prefix string) (bool, error) {
+	res := r.db.Where("prefix = ?", prefix).Delete(&entities.ShardDirectoryEntity{})
+	return res.RowsAffected > 0, res.Error
+}
+
+func (r *shardDirectoryRepository) CountLinks(prefix string) (int64, error) {
+	var count int64
+	err := r.db.Model(&entities.URLEntity{}).Where("starts_with(shortcode, ?)", prefix).Count(&count).Error
+	return count, err
+}
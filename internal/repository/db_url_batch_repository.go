@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"strings"
+
+	"github.com/rodrigocitadin/url-shortener/internal/entities"
+	"gorm.io/gorm"
+)
+
+const urlBatchColumns = 7
+
+type URLBatchRepository interface {
+	// InsertBatch inserts the links with a single statement, skipping those
+	// whose shortcode is already taken, and returns the shortcodes it
+	// actually inserted.
+	InsertBatch(urls []*entities.URLEntity) ([]string, error)
+}
+
+type urlBatchRepository struct {
+	db *gorm.DB
+}
+
+func NewDatabaseURLBatchRepository(db *gorm.DB) URLBatchRepository {
+	return &urlBatchRepository{db: db}
+}
+
+func (r *urlBatchRepository) InsertBatch(urls []*entities.URLEntity) ([]string, error) {
+	if len(urls) == 0 {
+		return nil, nil
+	}
+
+	var query strings.Builder
+	query.WriteString("INSERT INTO urls (shortcode, url, accesses, redirect_type, expires_at, max_clicks, exhausted_at) VALUES ")
+
+	args := make([]any, 0, len(urls)*urlBatchColumns)
+	for i, u := range urls {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		query.WriteString("(?, ?, ?, ?, ?, ?, ?)")
+		args = append(args, u.Shortcode, u.URL, u.Accesses, u.RedirectType, u.ExpiresAt, u.MaxClicks, u.ExhaustedAt)
+	}
+	query.WriteString(" ON CONFLICT DO NOTHING RETURNING shortcode")
+
+	var inserted []string
+	err := r.db.Raw(query.String(), args...).Scan(&inserted).Error
+	return inserted, err
+}
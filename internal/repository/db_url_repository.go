@@ -1,13 +1,31 @@
 package repository
 
 import (
+	stderrors "errors"
+	"slices"
+
 	"github.com/rodrigocitadin/url-shortener/internal/entities"
+	"github.com/rodrigocitadin/url-shortener/internal/errors"
 	"gorm.io/gorm"
 )
 
+// WriteStatus tells whether a saved link is already in the database or only
+// queued for the worker to insert.
+type WriteStatus string
+
+const (
+	WritePending   WriteStatus = "pending"
+	WritePersisted WriteStatus = "persisted"
+)
+
 type URLRepository interface {
-	Save(urlEntity *entities.URLEntity) error
+	Save(urlEntity *entities.URLEntity) (WriteStatus, error)
+	// Update writes the given columns of urlEntity to the link with the
+	// same shortcode.
+	Update(urlEntity *entities.URLEntity, columns []string) (WriteStatus, error)
+	Delete(shortCode string) (WriteStatus, error)
 	Find(shortCode string) (*entities.URLEntity, error)
+	Exists(shortCode string) (bool, error)
 }
 
 type urlRepository struct {
@@ -18,12 +36,62 @@ func NewDatabaseURLRepository(db *gorm.DB) URLRepository {
 	return &urlRepository{db: db}
 }
 
-func (c *urlRepository) Save(urlEntity *entities.URLEntity) error {
-	return c.db.Create(&urlEntity).Error
+func (c *urlRepository) Save(urlEntity *entities.URLEntity) (WriteStatus, error) {
+	if err := c.db.Create(&urlEntity).Error; err != nil {
+		return "", err
+	}
+	return WritePersisted, nil
+}
+
+func (r *urlRepository) Update(urlEntity *entities.URLEntity, columns []string) (WriteStatus, error) {
+	res := r.db.Model(&entities.URLEntity{}).
+		Where("shortcode = ?", urlEntity.Shortcode).
+		Select(columns).
+		Updates(urlEntity)
+	if res.Error != nil {
+		return "", res.Error
+	}
+	if res.RowsAffected == 0 {
+		return "", errors.NewNotFound("shortcode not found", "repository", nil)
+	}
+
+	// a new click limit may exhaust the link or bring it back
+	if slices.Contains(columns, "max_clicks") {
+		err := r.db.Model(&entities.URLEntity{}).
+			Where("shortcode = ?", urlEntity.Shortcode).
+			UpdateColumn("exhausted_at", gorm.Expr(exhaustedAtSQL, 0)).Error
+		if err != nil {
+			return "", err
+		}
+	}
+	return WritePersisted, nil
+}
+
+func (r *urlRepository) Delete(shortCode string) (WriteStatus, error) {
+	res := r.db.Where("shortcode = ?", shortCode).Delete(&entities.URLEntity{})
+	if res.Error != nil {
+		return "", res.Error
+	}
+	if res.RowsAffected == 0 {
+		return "", errors.NewNotFound("shortcode not found", "repository", nil)
+	}
+	return WritePersisted, nil
 }
 
 func (r *urlRepository) Find(shortCode string) (*entities.URLEntity, error) {
 	var urlEntity entities.URLEntity
-	err := r.db.Find(&urlEntity, "shortcode = ?", shortCode).Error
-	return &urlEntity, err
+	err := r.db.Where("shortcode = ?", shortCode).First(&urlEntity).Error
+	if stderrors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.NewNotFound("shortcode not found", "repository", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &urlEntity, nil
+}
+
+func (r *urlRepository) Exists(shortCode string) (bool, error) {
+	var count int64
+	err := r.db.Model(&entities.URLEntity{}).Where("shortcode = ?", shortCode).Count(&count).Error
+	return count > 0, err
 }
@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"sort"
+	"time"
+
+	"github.com/rodrigocitadin/url-shortener/internal/entities"
+)
+
+// dualReadClickRollupRepository sums the rollups of a link over both shards
+// during a migration: new clicks are aggregated on the current shard while
+// the older ones stay on the previous shard until cmd/reshard moved them.
+type dualReadClickRollupRepository struct {
+	current  ClickRollupRepository
+	previous ClickRollupRepository
+}
+
+// NewShardClickRollupRepository returns the rollups repository of the shard
+// owning shardingKey, wrapped for dual reads while a migration is running.
+func NewShardClickRollupRepository(sm *ShardManager, shardingKey string) ClickRollupRepository {
+	current := NewDatabaseClickRollupRepository(sm.GetShard(sm.GetShardIndex(shardingKey)))
+
+	prevIdx, ok := sm.PreviousShardIndex(shardingKey)
+	if !ok {
+		return current
+	}
+	return &dualReadClickRollupRepository{
+		current:  current,
+		previous: NewDatabaseClickRollupRepository(sm.GetShard(prevIdx)),
+	}
+}
+
+func (r *dualReadClickRollupRepository) Add(rollups []entities.ClickRollupEntity) error {
+	return r.current.Add(rollups)
+}
+
+func (r *dualReadClickRollupRepository) Series(shortCode string, from, to time.Time, granularity string) ([]ClickBucket, error) {
+	current, err := r.current.Series(shortCode, from, to, granularity)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := r.previous.Series(shortCode, from, to, granularity)
+	if err != nil {
+		return nil, err
+	}
+
+	sums := make(map[time.Time]int64, len(current)+len(previous))
+	for _, b := range append(current, previous...) {
+		sums[b.Bucket] += b.Clicks
+	}
+
+	buckets := make([]ClickBucket, 0, len(sums))
+	for bucket, clicks := range sums {
+		buckets = append(buckets, ClickBucket{Bucket: bucket, Clicks: clicks})
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Bucket.Before(buckets[j].Bucket)
+	})
+	return buckets, nil
+}
+
+// TopReferrers ranks the referrers over both shards, so neither side can be
+// cut at limit before the sums are known.
+func (r *dualReadClickRollupRepository) TopReferrers(shortCode string, from, to time.Time, limit int) ([]ReferrerCount, error) {
+	current, err := r.current.TopReferrers(shortCode, from, to, 0)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := r.previous.TopReferrers(shortCode, from, to, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	sums := make(map[string]int64, len(current)+len(previous))
+	for _, rc := range append(current, previous...) {
+		sums[rc.Referrer] += rc.Clicks
+	}
+
+	referrers := make([]ReferrerCount, 0, len(sums))
+	for referrer, clicks := range sums {
+		referrers = append(referrers, ReferrerCount{Referrer: referrer, Clicks: clicks})
+	}
+	sort.Slice(referrers, func(i, j int) bool {
+		return referrers[i].Clicks > referrers[j].Clicks
+	})
+	if limit > 0 && len(referrers) > limit {
+		referrers = referrers[:limit]
+	}
+	return referrers, nil
+}
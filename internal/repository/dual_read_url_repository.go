@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"github.com/rodrigocitadin/url-shortener/internal/entities"
+	"github.com/rodrigocitadin/url-shortener/internal/errors"
+)
+
+// dualReadURLRepository serves a link during a shard migration: new links
+// go to the current shard, everything else also tries the previous one,
+// where rows stay until cmd/reshard moved them.
+type dualReadURLRepository struct {
+	current  URLRepository
+	previous URLRepository
+}
+
+// NewShardURLRepository returns the database repository of the shard owning
+// shardingKey, wrapped for dual reads while a migration is running.
+func NewShardURLRepository(sm *ShardManager, shardingKey string) URLRepository {
+	current := NewDatabaseURLRepository(sm.GetShard(sm.GetShardIndex(shardingKey)))
+
+	prevIdx, ok := sm.PreviousShardIndex(shardingKey)
+	if !ok {
+		return current
+	}
+	return NewDualReadURLRepository(current, NewDatabaseURLRepository(sm.GetShard(prevIdx)))
+}
+
+func NewDualReadURLRepository(current, previous URLRepository) URLRepository {
+	return &dualReadURLRepository{current: current, previous: previous}
+}
+
+func (r *dualReadURLRepository) Save(urlEntity *entities.URLEntity) (WriteStatus, error) {
+	return r.current.Save(urlEntity)
+}
+
+func (r *dualReadURLRepository) Update(urlEntity *entities.URLEntity, columns []string) (WriteStatus, error) {
+	status, err := r.current.Update(urlEntity, columns)
+	if !errors.IsNotFound(err) {
+		return status, err
+	}
+
+	status, err = r.previous.Update(urlEntity, columns)
+	if !errors.IsNotFound(err) {
+		return status, err
+	}
+
+	// the row may have been moved between both attempts
+	return r.current.Update(urlEntity, columns)
+}
+
+// Delete removes the link from both shards, so a copy left behind can't be
+// moved back to life later.
+func (r *dualReadURLRepository) Delete(shortCode string) (WriteStatus, error) {
+	status, err := r.current.Delete(shortCode)
+	if err != nil && !errors.IsNotFound(err) {
+		return status, err
+	}
+
+	prevStatus, prevErr := r.previous.Delete(shortCode)
+	if prevErr == nil {
+		return prevStatus, nil
+	}
+	if !errors.IsNotFound(prevErr) {
+		return prevStatus, prevErr
+	}
+	return status, err
+}
+
+func (r *dualReadURLRepository) Find(shortCode string) (*entities.URLEntity, error) {
+	entity, err := r.current.Find(shortCode)
+	if !errors.IsNotFound(err) {
+		return entity, err
+	}
+	return r.previous.Find(shortCode)
+}
+
+func (r *dualReadURLRepository) Exists(shortCode string) (bool, error) {
+	exists, err := r.current.Exists(shortCode)
+	if err != nil || exists {
+		return exists, err
+	}
+	return r.previous.Exists(shortCode)
+}
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rodrigocitadin/url-shortener/internal/broker"
+	"github.com/rodrigocitadin/url-shortener/internal/entities"
+)
+
+const ClicksExchange = "clicks_exchange"
+
+type ClickPublisher interface {
+	Publish(event *entities.ClickEvent) error
+}
+
+type queueClickPublisher struct {
+	channel  *broker.Channel
+	exchange string
+}
+
+type noopClickPublisher struct{}
+
+func NewQueueClickPublisher(ch *broker.Channel) ClickPublisher {
+	if ch == nil {
+		return noopClickPublisher{}
+	}
+	return &queueClickPublisher{
+		channel:  ch,
+		exchange: ClicksExchange,
+	}
+}
+
+// Publish sends the event as a transient message; losing a click on a broker
+// restart is acceptable, slowing down redirects is not.
+func (p *queueClickPublisher) Publish(event *entities.ClickEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ch := p.channel.Current()
+	if ch == nil {
+		return broker.ErrNotConnected
+	}
+
+	return ch.PublishWithContext(ctx, p.exchange, "", false, false, amqp.Publishing{
+		DeliveryMode: amqp.Transient,
+		ContentType:  "application/json",
+		Body:         body,
+	})
+}
+
+func (noopClickPublisher) Publish(*entities.ClickEvent) error {
+	return nil
+}
@@ -0,0 +1,205 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	ErrPublishNacked      = errors.New("broker nacked the message")
+	ErrPublishUnroutable  = errors.New("broker returned the message as unroutable")
+	ErrPublisherClosed    = errors.New("publisher channel closed")
+	errConfirmWaitTimeout = errors.New("timed out waiting for publisher confirm")
+)
+
+// Publisher publishes a message and only returns nil once the broker has
+// taken responsibility for it.
+type Publisher interface {
+	Publish(exchange, key string, msg amqp.Publishing) error
+}
+
+// ConfirmingPublisher publishes mandatory messages in confirm mode, so a
+// message no queue is bound for comes back as an error instead of being
+// dropped silently. It publishes on the last channel attached to it.
+type ConfirmingPublisher struct {
+	timeout time.Duration
+
+	mu      sync.RWMutex
+	session *confirmSession
+}
+
+// confirmSession tracks the returns of a single channel; delivery tags start
+// over on every channel.
+type confirmSession struct {
+	channel *amqp.Channel
+
+	mu       sync.Mutex
+	handled  uint64                   // highest delivery tag the listener went past
+	waiters  map[uint64]chan struct{} // by delivery tag
+	returned map[string]bool          // by message id
+	closed   bool
+}
+
+func NewConfirmingPublisher(timeout time.Duration) *ConfirmingPublisher {
+	return &ConfirmingPublisher{timeout: timeout}
+}
+
+// Attach puts ch in confirm mode and publishes on it from now on. It runs
+// every time the channel is reopened; until the first call Publish fails.
+func (p *ConfirmingPublisher) Attach(ch *amqp.Channel) error {
+	if err := ch.Confirm(false); err != nil {
+		return fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+
+	s := &confirmSession{
+		channel:  ch,
+		waiters:  make(map[uint64]chan struct{}),
+		returned: make(map[string]bool),
+	}
+
+	// Both channels are read by a single goroutine. The broker sends
+	// basic.return before the basic.ack of the same message and the client
+	// dispatches frames in order, so once the listener went past a delivery
+	// tag its return, if any, is recorded.
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation))
+	returns := ch.NotifyReturn(make(chan amqp.Return))
+	go s.listen(confirms, returns)
+
+	p.mu.Lock()
+	p.session = s
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *ConfirmingPublisher) Publish(exchange, key string, msg amqp.Publishing) error {
+	p.mu.RLock()
+	s := p.session
+	p.mu.RUnlock()
+
+	if s == nil {
+		return ErrPublisherClosed
+	}
+
+	if msg.MessageId == "" {
+		id, err := newMessageID()
+		if err != nil {
+			return err
+		}
+		msg.MessageId = id
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	return s.publish(ctx, exchange, key, msg)
+}
+
+func (s *confirmSession) publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	confirm, err := s.channel.PublishWithDeferredConfirmWithContext(ctx, exchange, key, true, false, msg)
+	if err != nil {
+		return err
+	}
+	defer s.forget(msg.MessageId)
+
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return errConfirmWaitTimeout
+	}
+	if !acked {
+		return ErrPublishNacked
+	}
+
+	if err := s.waitHandled(ctx, confirm.DeliveryTag); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	returned := s.returned[msg.MessageId]
+	s.mu.Unlock()
+
+	if returned {
+		return ErrPublishUnroutable
+	}
+	return nil
+}
+
+// waitHandled blocks until the listener went past tag. The library resolves
+// the deferred confirmation right before notifying the listener, so this is
+// a short wait.
+func (s *confirmSession) waitHandled(ctx context.Context, tag uint64) error {
+	s.mu.Lock()
+	if s.handled >= tag || s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	w := make(chan struct{})
+	s.waiters[tag] = w
+	s.mu.Unlock()
+
+	select {
+	case <-w:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		delete(s.waiters, tag)
+		s.mu.Unlock()
+		return errConfirmWaitTimeout
+	}
+}
+
+func (s *confirmSession) forget(messageID string) {
+	s.mu.Lock()
+	delete(s.returned, messageID)
+	s.mu.Unlock()
+}
+
+// listen never blocks on anything a publisher holds while calling into the
+// client: the client notifies listeners with its own publish lock held.
+func (s *confirmSession) listen(confirms <-chan amqp.Confirmation, returns <-chan amqp.Return) {
+	for {
+		select {
+		case r, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			s.mu.Lock()
+			s.returned[r.MessageId] = true
+			s.mu.Unlock()
+
+		case c, ok := <-confirms:
+			s.mu.Lock()
+			if !ok {
+				s.closed = true
+			} else {
+				s.handled = c.DeliveryTag
+			}
+			for tag, w := range s.waiters {
+				if s.closed || tag <= s.handled {
+					close(w)
+					delete(s.waiters, tag)
+				}
+			}
+			s.mu.Unlock()
+
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+func newMessageID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -1,52 +1,114 @@
 package repository
 
 import (
-	"context"
 	"encoding/json"
 	"log"
-	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/rodrigocitadin/url-shortener/internal/entities"
+	"github.com/rodrigocitadin/url-shortener/internal/errors"
+	"github.com/rodrigocitadin/url-shortener/internal/messages"
 )
 
 type queueURLRepository struct {
-	channel   *amqp.Channel
+	publisher Publisher
 	queueName string
 	fallback  URLRepository
+	pending   PendingURLs
+	requestID string
 }
 
-func NewQueueURLRepository(ch *amqp.Channel, fallback URLRepository) URLRepository {
+func NewQueueURLRepository(publisher Publisher, fallback URLRepository, pending PendingURLs, requestID string) URLRepository {
 	return &queueURLRepository{
-		channel:   ch,
+		publisher: publisher,
 		queueName: "urls_queue",
 		fallback:  fallback,
+		pending:   pending,
+		requestID: requestID,
 	}
 }
 
-func (r *queueURLRepository) Save(urlEntity *entities.URLEntity) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// Save claims the pending record before publishing, so two queued links
+// can't both be served under the same shortcode until the worker rejects one.
+func (r *queueURLRepository) Save(urlEntity *entities.URLEntity) (WriteStatus, error) {
+	claimed, err := r.pending.Put(urlEntity)
+	if err != nil {
+		log.Printf("Redis error: %v. Link %s not readable until the worker inserts it.", err, urlEntity.Shortcode)
+	} else if !claimed {
+		return "", errors.NewConflict("shortcode already taken", "repository", nil)
+	}
 
-	body, err := json.Marshal(urlEntity)
+	err = r.publish(messages.OperationCreate, &messages.URLPayload{URL: urlEntity})
 	if err != nil {
-		return err
+		log.Printf("RabbitMQ error: %v. Using Fallback to DB.", err)
+		if claimed {
+			r.pending.Drop(urlEntity.Shortcode)
+		}
+		return r.fallback.Save(urlEntity)
 	}
 
-	err = r.channel.PublishWithContext(ctx, "", r.queueName, false, false, amqp.Publishing{
-		DeliveryMode: amqp.Persistent,
-		ContentType:  "application/json",
-		Body:         body,
-	})
+	return WritePending, nil
+}
 
+func (r *queueURLRepository) Update(urlEntity *entities.URLEntity, columns []string) (WriteStatus, error) {
+	err := r.publish(messages.OperationUpdate, &messages.URLPayload{URL: urlEntity, Columns: columns})
 	if err != nil {
 		log.Printf("RabbitMQ error: %v. Using Fallback to DB.", err)
-		return r.fallback.Save(urlEntity)
+		return r.fallback.Update(urlEntity, columns)
 	}
 
-	return nil
+	// a link still waiting for its insert is read from the pending record
+	r.pending.Replace(urlEntity)
+
+	return WritePending, nil
+}
+
+func (r *queueURLRepository) Delete(shortCode string) (WriteStatus, error) {
+	err := r.publish(messages.OperationDelete, &messages.URLPayload{URL: &entities.URLEntity{Shortcode: shortCode}})
+	if err != nil {
+		log.Printf("RabbitMQ error: %v. Using Fallback to DB.", err)
+		return r.fallback.Delete(shortCode)
+	}
+
+	r.pending.Drop(shortCode)
+
+	return WritePending, nil
 }
 
 func (r *queueURLRepository) Find(shortCode string) (*entities.URLEntity, error) {
+	if entity, err := r.pending.Get(shortCode); err == nil && entity != nil {
+		return entity, nil
+	}
 	return r.fallback.Find(shortCode)
 }
+
+func (r *queueURLRepository) Exists(shortCode string) (bool, error) {
+	if entity, err := r.pending.Get(shortCode); err == nil && entity != nil {
+		return true, nil
+	}
+	return r.fallback.Exists(shortCode)
+}
+
+// publish returns nil only once the broker confirmed the message and routed
+// it to the queue, anything else sends the write to the fallback.
+func (r *queueURLRepository) publish(operation string, payload *messages.URLPayload) error {
+	env, err := messages.New(operation, r.requestID, payload)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	return r.publisher.Publish("", r.queueName, amqp.Publishing{
+		DeliveryMode:  amqp.Persistent,
+		ContentType:   "application/json",
+		MessageId:     env.IdempotencyKey,
+		CorrelationId: env.RequestID,
+		Timestamp:     env.Timestamp,
+		Type:          env.Operation,
+		Body:          body,
+	})
+}
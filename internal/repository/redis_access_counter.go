@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	accessesKey      = "accesses:"
+	accessesDirtyKey = "accesses-dirty" // shortcodes with increments not yet flushed
+)
+
+// AccessCounter buffers redirect counts in Redis so the redirect path never
+// writes to Postgres. The worker drains the buffer and applies it per shard.
+type AccessCounter interface {
+	Increment(shortCode string) error
+	Pending(shortCode string) (int64, error)
+	Drain(limit int) (map[string]int64, error)
+	Restore(counts map[string]int64) error
+}
+
+type redisAccessCounter struct {
+	redis *redis.Client
+}
+
+func NewRedisAccessCounter(redisClient *redis.Client) AccessCounter {
+	return &redisAccessCounter{redis: redisClient}
+}
+
+func (c *redisAccessCounter) Increment(shortCode string) error {
+	ctx := context.Background()
+
+	_, err := c.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Incr(ctx, accessesKey+shortCode)
+		pipe.SAdd(ctx, accessesDirtyKey, shortCode)
+		return nil
+	})
+	return err
+}
+
+func (c *redisAccessCounter) Pending(shortCode string) (int64, error) {
+	ctx := context.Background()
+
+	n, err := c.redis.Get(ctx, accessesKey+shortCode).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return n, err
+}
+
+// Drain takes up to limit shortcodes out of the buffer together with their
+// pending counts. Increments that race with Drain re-mark the shortcode as
+// dirty, so they are picked up by the next call.
+func (c *redisAccessCounter) Drain(limit int) (map[string]int64, error) {
+	ctx := context.Background()
+
+	codes, err := c.redis.SPopN(ctx, accessesDirtyKey, int64(limit)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(codes))
+	for _, code := range codes {
+		n, err := c.redis.GetDel(ctx, accessesKey+code).Int64()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			c.Restore(counts)
+			return nil, err
+		}
+		if n > 0 {
+			counts[code] = n
+		}
+	}
+
+	return counts, nil
+}
+
+// Restore puts drained counts back, used when applying them to a shard fails.
+func (c *redisAccessCounter) Restore(counts map[string]int64) error {
+	ctx := context.Background()
+
+	_, err := c.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for code, n := range counts {
+			pipe.IncrBy(ctx, accessesKey+code, n)
+			pipe.SAdd(ctx, accessesDirtyKey, code)
+		}
+		return nil
+	})
+	return err
+}
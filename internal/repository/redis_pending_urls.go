@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rodrigocitadin/url-shortener/internal/entities"
+)
+
+const (
+	pendingTTL = 1
+	pendingKey = "url-pending:"
+)
+
+// PendingURLs holds links that were queued but not inserted yet, so reads
+// right after a POST see them while the queue drains. The worker releases
+// them once the insert commits, and reads go to the shard from then on.
+type PendingURLs interface {
+	// Put records a queued link unless another one already holds its
+	// shortcode, and reports whether it did.
+	Put(urlEntity *entities.URLEntity) (bool, error)
+	// Replace overwrites the record of a link that is still pending and
+	// does nothing otherwise.
+	Replace(urlEntity *entities.URLEntity) error
+	Get(shortCode string) (*entities.URLEntity, error)
+	// Release drops the pending record and any entity cached from it in one
+	// step, so the next read caches what the shard holds. Writing the queued
+	// entity instead would bring back an update or delete issued meanwhile.
+	Release(shortCode string) error
+	Drop(shortCode string) error
+}
+
+type redisPendingURLs struct {
+	redis *redis.Client
+}
+
+func NewRedisPendingURLs(redisClient *redis.Client) PendingURLs {
+	return &redisPendingURLs{redis: redisClient}
+}
+
+func (p *redisPendingURLs) Put(urlEntity *entities.URLEntity) (bool, error) {
+	ctx := context.Background()
+
+	data, err := json.Marshal(urlEntity)
+	if err != nil {
+		return false, err
+	}
+
+	ttl := min(pendingTTL*time.Hour, entityTTL(urlEntity))
+	if ttl <= 0 {
+		return true, nil
+	}
+	return p.redis.SetNX(ctx, pendingKey+urlEntity.Shortcode, data, ttl).Result()
+}
+
+func (p *redisPendingURLs) Replace(urlEntity *entities.URLEntity) error {
+	ctx := context.Background()
+
+	data, err := json.Marshal(urlEntity)
+	if err != nil {
+		return err
+	}
+
+	ttl := min(pendingTTL*time.Hour, entityTTL(urlEntity))
+	if ttl <= 0 {
+		return p.redis.Del(ctx, pendingKey+urlEntity.Shortcode).Err()
+	}
+	return p.redis.SetXX(ctx, pendingKey+urlEntity.Shortcode, data, ttl).Err()
+}
+
+// Get returns nil without error when nothing is pending for shortCode.
+func (p *redisPendingURLs) Get(shortCode string) (*entities.URLEntity, error) {
+	ctx := context.Background()
+
+	val, err := p.redis.Get(ctx, pendingKey+shortCode).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entity entities.URLEntity
+	if err := json.Unmarshal(val, &entity); err != nil {
+		return nil, err
+	}
+	return &entity, nil
+}
+
+func (p *redisPendingURLs) Release(shortCode string) error {
+	ctx := context.Background()
+	return p.redis.Del(ctx, pendingKey+shortCode, cacheKey+shortCode).Err()
+}
+
+func (p *redisPendingURLs) Drop(shortCode string) error {
+	ctx := context.Background()
+	return p.redis.Del(ctx, pendingKey+shortCode).Err()
+}
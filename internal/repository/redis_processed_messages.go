@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	processedTTL = 24
+	processedKey = "msg-done:"
+)
+
+// ProcessedMessages remembers the idempotency keys of the messages the worker
+// already applied, so a redelivered message is not applied twice.
+type ProcessedMessages interface {
+	Seen(key string) (bool, error)
+	Mark(key string) error
+}
+
+type redisProcessedMessages struct {
+	redis *redis.Client
+}
+
+func NewRedisProcessedMessages(redisClient *redis.Client) ProcessedMessages {
+	return &redisProcessedMessages{redis: redisClient}
+}
+
+func (p *redisProcessedMessages) Seen(key string) (bool, error) {
+	ctx := context.Background()
+	n, err := p.redis.Exists(ctx, processedKey+key).Result()
+	return n > 0, err
+}
+
+func (p *redisProcessedMessages) Mark(key string) error {
+	ctx := context.Background()
+	return p.redis.Set(ctx, processedKey+key, 1, processedTTL*time.Hour).Err()
+}
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	tombstoneTTL = 24
+	tombstoneKey = "url-deleted:"
+)
+
+// Tombstones remember recently deleted shortcodes so creates and updates
+// still sitting in the queue don't bring a deleted link back.
+type Tombstones interface {
+	Mark(shortCode string) error
+	Clear(shortCode string) error
+	Has(shortCode string) (bool, error)
+}
+
+type redisTombstones struct {
+	redis *redis.Client
+}
+
+func NewRedisTombstones(redisClient *redis.Client) Tombstones {
+	return &redisTombstones{redis: redisClient}
+}
+
+func (t *redisTombstones) Mark(shortCode string) error {
+	ctx := context.Background()
+	return t.redis.Set(ctx, tombstoneKey+shortCode, 1, tombstoneTTL*time.Hour).Err()
+}
+
+func (t *redisTombstones) Clear(shortCode string) error {
+	ctx := context.Background()
+	return t.redis.Del(ctx, tombstoneKey+shortCode).Err()
+}
+
+func (t *redisTombstones) Has(shortCode string) (bool, error) {
+	ctx := context.Background()
+	n, err := t.redis.Exists(ctx, tombstoneKey+shortCode).Result()
+	return n > 0, err
+}
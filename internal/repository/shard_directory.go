@@ -0,0 +1,209 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rodrigocitadin/url-shortener/internal/entities"
+	"github.com/rodrigocitadin/url-shortener/internal/sharding"
+	"gorm.io/gorm"
+)
+
+const (
+	shardDirectoryKey     = "shard-directory"
+	shardDirectoryTTL     = 5 * time.Minute
+	shardDirectoryChannel = "shard-directory:changed"
+)
+
+type directoryEntry struct {
+	prefix string
+	shard  int
+}
+
+// directoryResolver places keys by the longest matching prefix in the
+// shard_directory table of the directory shard, and everything else with the
+// hash resolver it wraps. The table is cached in Redis, shared by every
+// process, and in memory, where Resolve reads it without any I/O. Changes
+// made with cmd/directory are announced on shardDirectoryChannel and every
+// process reloads the table right away; the periodic refresh only covers
+// announcements a process missed.
+//
+// Processes still switch a moment apart, so a link created meanwhile may
+// land on the shard its prefix just left. cmd/directory therefore refuses
+// to change prefixes that have links and warns if one shows up.
+type directoryResolver struct {
+	sm       *ShardManager
+	source   *gorm.DB
+	rdb      *redis.Client // nil reads the table directly
+	fallback sharding.ShardResolver
+
+	entries atomic.Pointer[[]directoryEntry] // longest prefix first
+}
+
+// EnableDirectory puts the shard directory, read from the shard with the
+// given ID, in front of the hash resolver. It loads the directory once before
+// returning and refreshes it every refresh until ctx is done. Call it before
+// the ShardManager is shared.
+func (sm *ShardManager) EnableDirectory(ctx context.Context, shardID string, rdb *redis.Client, refresh time.Duration) error {
+	idx, ok := sm.ShardIndex(shardID)
+	if !ok {
+		return fmt.Errorf("unknown directory shard %q", shardID)
+	}
+
+	r := &directoryResolver{
+		sm:       sm,
+		source:   sm.GetShard(idx),
+		rdb:      rdb,
+		fallback: sm.resolver,
+	}
+
+	if err := r.load(ctx); err != nil {
+		return err
+	}
+	sm.resolver = r
+
+	go r.run(ctx, refresh)
+	return nil
+}
+
+func (r *directoryResolver) Resolve(key string) int {
+	for _, e := range *r.entries.Load() {
+		if strings.HasPrefix(key, e.prefix) {
+			return e.shard
+		}
+	}
+	return r.fallback.Resolve(key)
+}
+
+func (r *directoryResolver) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var changes <-chan *redis.Message
+	if r.rdb != nil {
+		sub := r.rdb.Subscribe(ctx, shardDirectoryChannel)
+		defer sub.Close()
+		changes = sub.Channel()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.load(ctx); err != nil {
+				log.Printf("Shard directory refresh failed: %v. Keeping the last one.", err)
+			}
+		case <-changes:
+			// straight from the table: another process may have cached the
+			// old one again before the change was announced
+			if err := r.reload(ctx); err != nil {
+				log.Printf("Shard directory reload failed: %v. Keeping the last one.", err)
+			}
+		}
+	}
+}
+
+func (r *directoryResolver) load(ctx context.Context) error {
+	table, err := r.fromRedis(ctx)
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("Redis error: %v. Reading the shard directory from the database.", err)
+		}
+		return r.reload(ctx)
+	}
+
+	r.use(table)
+	return nil
+}
+
+func (r *directoryResolver) reload(ctx context.Context) error {
+	table, err := r.fromDatabase()
+	if err != nil {
+		return err
+	}
+	r.toRedis(ctx, table)
+
+	r.use(table)
+	return nil
+}
+
+func (r *directoryResolver) use(table map[string]string) {
+	entries := make([]directoryEntry, 0, len(table))
+	for prefix, id := range table {
+		idx, ok := r.sm.ShardIndex(id)
+		if !ok {
+			log.Printf("Shard directory pins %q to unknown shard %s; ignoring it", prefix, id)
+			continue
+		}
+		entries = append(entries, directoryEntry{prefix: prefix, shard: idx})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return len(entries[i].prefix) > len(entries[j].prefix)
+	})
+
+	r.entries.Store(&entries)
+}
+
+// AnnounceDirectoryChange drops the cached shard directory and tells every
+// process to reload it from the table. Call it after each change to the
+// table.
+func AnnounceDirectoryChange(ctx context.Context, rdb *redis.Client) error {
+	if err := rdb.Del(ctx, shardDirectoryKey).Err(); err != nil {
+		return err
+	}
+	return rdb.Publish(ctx, shardDirectoryChannel, "").Err()
+}
+
+func (r *directoryResolver) fromRedis(ctx context.Context) (map[string]string, error) {
+	if r.rdb == nil {
+		return nil, redis.Nil
+	}
+
+	data, err := r.rdb.Get(ctx, shardDirectoryKey).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var table map[string]string
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+func (r *directoryResolver) fromDatabase() (map[string]string, error) {
+	var rows []entities.ShardDirectoryEntity
+	if err := r.source.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	table := make(map[string]string, len(rows))
+	for _, row := range rows {
+		table[row.Prefix] = row.ShardID
+	}
+	return table, nil
+}
+
+func (r *directoryResolver) toRedis(ctx context.Context, table map[string]string) {
+	if r.rdb == nil {
+		return
+	}
+
+	data, err := json.Marshal(table)
+	if err != nil {
+		return
+	}
+	if err := r.rdb.Set(ctx, shardDirectoryKey, data, shardDirectoryTTL).Err(); err != nil {
+		log.Printf("Redis error: %v. Shard directory not cached.", err)
+	}
+}
@@ -2,56 +2,150 @@ package repository
 
 import (
 	"fmt"
-	"hash/fnv"
 	"log"
 
+	"github.com/rodrigocitadin/url-shortener/internal/sharding"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
 type ShardManager struct {
-	shards []*gorm.DB
+	shards   []*gorm.DB
+	ids      []string
+	resolver sharding.ShardResolver
+
+	// previous places keys by the topology being migrated away from; nil
+	// outside a migration. Shards only that topology knows are connected too
+	// and sit after the current ones.
+	previous sharding.ShardResolver
 }
 
-func NewShardManager(dsns []string) (*ShardManager, error) {
-	var conns []*gorm.DB
+func NewShardManager(cfg sharding.Config) (*ShardManager, error) {
+	resolver, err := cfg.NewResolver()
+	if err != nil {
+		return nil, err
+	}
 
-	for i, dsn := range dsns {
-		db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
-			Logger: logger.Default.LogMode(logger.Silent),
-		})
-		if err != nil {
-			return nil, fmt.Errorf("failed to connect to shard %d: %w", i, err)
+	sm := &ShardManager{resolver: resolver}
+	for i, dsn := range cfg.DSNs {
+		if err := sm.connect(cfg.IDs[i], dsn); err != nil {
+			return nil, err
 		}
+	}
+	log.Printf("placing keys with %s over %d shards", cfg.Placement, len(sm.shards))
 
-		sqlDB, err := db.DB()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get sql.DB from shard %d: %w", i, err)
+	if cfg.Previous != nil {
+		if err := sm.enableDualRead(*cfg.Previous); err != nil {
+			return nil, err
 		}
+	}
 
-		if err := sqlDB.Ping(); err != nil {
-			return nil, fmt.Errorf("shard %d unreachable: %w", i, err)
-		}
+	return sm, nil
+}
+
+func (sm *ShardManager) connect(id, dsn string) error {
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to connect to shard %s: %w", id, err)
+	}
 
-		sqlDB.SetMaxOpenConns(10)
-		sqlDB.SetMaxIdleConns(5)
+	sqlDB, err := db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get sql.DB from shard %s: %w", id, err)
+	}
 
-		conns = append(conns, db)
-		log.Printf("connected to shard %d (GORM)", i)
+	if err := sqlDB.Ping(); err != nil {
+		return fmt.Errorf("shard %s unreachable: %w", id, err)
+	}
+
+	sqlDB.SetMaxOpenConns(10)
+	sqlDB.SetMaxIdleConns(5)
+
+	sm.shards = append(sm.shards, db)
+	sm.ids = append(sm.ids, id)
+	log.Printf("connected to shard %s (GORM)", id)
+	return nil
+}
+
+// enableDualRead starts the migration window: keys keep being written to
+// their current shard, while reads that miss there also look where the
+// previous topology placed them.
+func (sm *ShardManager) enableDualRead(previous sharding.Config) error {
+	resolver, err := previous.NewResolver()
+	if err != nil {
+		return fmt.Errorf("previous topology: %w", err)
+	}
+
+	indexes := make([]int, len(previous.IDs))
+	for i, id := range previous.IDs {
+		idx, ok := sm.ShardIndex(id)
+		if !ok {
+			if err := sm.connect(id, previous.DSNs[i]); err != nil {
+				return err
+			}
+			idx = len(sm.shards) - 1
+		}
+		indexes[i] = idx
 	}
 
-	return &ShardManager{shards: conns}, nil
+	sm.previous = remappedResolver{resolver: resolver, indexes: indexes}
+	log.Printf("dual-read enabled: falling back to the %s placement over %d shards", previous.Placement, len(previous.IDs))
+	return nil
 }
 
 func (sm *ShardManager) GetShard(idx int) *gorm.DB {
 	return sm.shards[idx]
 }
 
+func (sm *ShardManager) ShardCount() int {
+	return len(sm.shards)
+}
+
+// ShardID returns the stable identifier of the shard at idx. Logs and
+// metrics should name shards by it, indexes change when DSNs are reordered.
+func (sm *ShardManager) ShardID(idx int) string {
+	return sm.ids[idx]
+}
+
+// ShardIndex returns the index of the shard with the given ID.
+func (sm *ShardManager) ShardIndex(id string) (int, bool) {
+	for idx, shardID := range sm.ids {
+		if shardID == id {
+			return idx, true
+		}
+	}
+	return 0, false
+}
+
+// GetShardIndex resolves key through the configured ShardResolver.
 func (sm *ShardManager) GetShardIndex(key string) int {
-	h := fnv.New32a()
-	h.Write([]byte(key))
-	hashValue := h.Sum32()
+	return sm.resolver.Resolve(key)
+}
+
+// PreviousShardIndex returns where the previous topology placed key, when a
+// migration is running and that is not where the key lives now.
+func (sm *ShardManager) PreviousShardIndex(key string) (int, bool) {
+	if sm.previous == nil {
+		return 0, false
+	}
+
+	idx := sm.previous.Resolve(key)
+	if idx == sm.GetShardIndex(key) {
+		return 0, false
+	}
+	return idx, true
+}
+
+// remappedResolver translates the indexes of another topology's resolver
+// into ShardManager indexes.
+type remappedResolver struct {
+	resolver sharding.ShardResolver
+	indexes  []int
+}
 
-	return int(hashValue % uint32(len(sm.shards)))
+func (r remappedResolver) Resolve(key string) int {
+	return r.indexes[r.resolver.Resolve(key)]
 }
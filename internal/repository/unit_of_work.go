@@ -1,64 +1,120 @@
 package repository
 
 import (
-	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/redis/go-redis/v9"
+	"github.com/rodrigocitadin/url-shortener/internal/broker"
 	"gorm.io/gorm"
 )
 
 type Factory interface {
 	URLS(shardingKey string) URLRepository
+	Outbox() OutboxRepository
 }
 
 type UnitOfWork interface {
+	// WithRequestID returns a unit of work whose queued writes carry the
+	// given producer request ID.
+	WithRequestID(requestID string) UnitOfWork
 	ExecuteTx(shardingKey string, fn func(Factory) error) error
 	URLS(shardingKey string) URLRepository
+	Accesses() AccessCounter
+	Clicks() ClickPublisher
+	ClickRollups(shardingKey string) ClickRollupRepository
 }
 
 type unitOfWork struct {
 	shardManager *ShardManager
 	redisClient  *redis.Client
-	amqpChannel  *amqp.Channel
+	amqpChannel  *broker.Channel
+	publisher    Publisher
+	requestID    string
 }
 
 type factory struct {
 	redisClient *redis.Client
 	db          *gorm.DB
+	previous    *gorm.DB // shard being migrated away from, outside the transaction
+	committed   []func() // cache side effects to apply once the transaction committed
 }
 
-func NewUnitOfWork(sm *ShardManager, rdb *redis.Client, ch *amqp.Channel) UnitOfWork {
+func NewUnitOfWork(sm *ShardManager, rdb *redis.Client, ch *broker.Channel, publisher Publisher) UnitOfWork {
 	return &unitOfWork{
 		shardManager: sm,
 		redisClient:  rdb,
 		amqpChannel:  ch,
+		publisher:    publisher,
 	}
 }
 
+func (f *unitOfWork) WithRequestID(requestID string) UnitOfWork {
+	uow := *f
+	uow.requestID = requestID
+	return &uow
+}
+
 func (f *unitOfWork) ExecuteTx(shardingKey string, fn func(Factory) error) error {
 	db := f.shardManager.GetShard(f.shardManager.GetShardIndex(shardingKey))
-	return db.Transaction(func(tx *gorm.DB) error {
-		txFactory := &factory{
-			db:          tx,
-			redisClient: f.redisClient,
-		}
+
+	var previous *gorm.DB
+	if idx, ok := f.shardManager.PreviousShardIndex(shardingKey); ok {
+		previous = f.shardManager.GetShard(idx)
+	}
+
+	txFactory := &factory{
+		redisClient: f.redisClient,
+		previous:    previous,
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		txFactory.db = tx
 		return fn(txFactory)
 	})
+	if err != nil {
+		return err
+	}
+
+	for _, effect := range txFactory.committed {
+		effect()
+	}
+	return nil
 }
 
 func (f *unitOfWork) URLS(shardingKey string) URLRepository {
-	db := f.shardManager.GetShard(f.shardManager.GetShardIndex(shardingKey))
-	pgRepo := NewDatabaseURLRepository(db)
+	pgRepo := NewShardURLRepository(f.shardManager, shardingKey)
 
 	var finalRepo URLRepository = pgRepo
 
-	if f.amqpChannel != nil {
-		finalRepo = NewQueueURLRepository(f.amqpChannel, pgRepo)
+	if f.publisher != nil {
+		finalRepo = NewQueueURLRepository(f.publisher, pgRepo, NewRedisPendingURLs(f.redisClient), f.requestID)
 	}
 
 	return NewCachedURLRepository(finalRepo, f.redisClient)
 }
 
+func (f *unitOfWork) Accesses() AccessCounter {
+	return NewRedisAccessCounter(f.redisClient)
+}
+
+func (f *unitOfWork) Clicks() ClickPublisher {
+	return NewQueueClickPublisher(f.amqpChannel)
+}
+
+func (f *unitOfWork) ClickRollups(shardingKey string) ClickRollupRepository {
+	return NewShardClickRollupRepository(f.shardManager, shardingKey)
+}
+
 func (f *factory) URLS(shardingKey string) URLRepository {
 	pgRepo := NewDatabaseURLRepository(f.db)
-	return NewCachedURLRepository(pgRepo, f.redisClient)
+	if f.previous != nil {
+		pgRepo = NewDualReadURLRepository(pgRepo, NewDatabaseURLRepository(f.previous))
+	}
+	return newTxCachedURLRepository(pgRepo, f.redisClient, f.afterCommit)
+}
+
+func (f *factory) afterCommit(effect func()) {
+	f.committed = append(f.committed, effect)
+}
+
+func (f *factory) Outbox() OutboxRepository {
+	return NewDatabaseOutboxRepository(f.db)
 }
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const base62Alphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+const sequenceKey = "shortcode:seq"
+
+// ShortcodeGenerator issues shortcodes for links stored without a custom one.
+// attempt starts at 0 and grows on every collision, so deterministic
+// strategies can derive a different code on each retry.
+type ShortcodeGenerator interface {
+	Generate(url string, attempt int) (string, error)
+}
+
+type randomGenerator struct {
+	length   int
+	alphabet []rune
+}
+
+type sequenceGenerator struct {
+	redis    *redis.Client
+	length   int
+	alphabet []rune
+}
+
+type hashGenerator struct {
+	length   int
+	alphabet []rune
+}
+
+// NewShortcodeGenerator builds the generator for strategy. alphabet holds the
+// characters codes are made of, usually ShortcodePolicy.GeneratorAlphabet,
+// and must not repeat any of them.
+func NewShortcodeGenerator(strategy string, length int, alphabet string, rdb *redis.Client) (ShortcodeGenerator, error) {
+	if length <= 0 {
+		return nil, fmt.Errorf("invalid shortcode length: %d", length)
+	}
+	if utf8.RuneCountInString(alphabet) < 2 {
+		return nil, fmt.Errorf("shortcode alphabet needs at least 2 characters: %q", alphabet)
+	}
+
+	switch strategy {
+	case "", "random":
+		return NewRandomGenerator(length, alphabet), nil
+	case "sequence":
+		return NewSequenceGenerator(rdb, length, alphabet), nil
+	case "hash":
+		return NewHashGenerator(length, alphabet), nil
+	default:
+		return nil, fmt.Errorf("unknown shortcode generator: %s", strategy)
+	}
+}
+
+func NewRandomGenerator(length int, alphabet string) ShortcodeGenerator {
+	return &randomGenerator{length: length, alphabet: []rune(alphabet)}
+}
+
+// NewSequenceGenerator left-pads the counter with zeros up to length, so the
+// first codes are not shorter than the ones issued by the other strategies.
+func NewSequenceGenerator(rdb *redis.Client, length int, alphabet string) ShortcodeGenerator {
+	return &sequenceGenerator{redis: rdb, length: length, alphabet: []rune(alphabet)}
+}
+
+func NewHashGenerator(length int, alphabet string) ShortcodeGenerator {
+	return &hashGenerator{length: length, alphabet: []rune(alphabet)}
+}
+
+func (g *randomGenerator) Generate(_ string, _ int) (string, error) {
+	max := big.NewInt(int64(len(g.alphabet)))
+	code := make([]rune, g.length)
+
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = g.alphabet[n.Int64()]
+	}
+
+	return string(code), nil
+}
+
+func (g *sequenceGenerator) Generate(_ string, _ int) (string, error) {
+	ctx := context.Background()
+
+	n, err := g.redis.Incr(ctx, sequenceKey).Result()
+	if err != nil {
+		return "", err
+	}
+
+	return encodeSequence(uint64(n), g.length, g.alphabet), nil
+}
+
+// encodeSequence encodes n in the base of alphabet, left-padded with its
+// first character up to length.
+func encodeSequence(n uint64, length int, alphabet []rune) string {
+	code := encode(n, alphabet)
+	if pad := length - utf8.RuneCountInString(code); pad > 0 {
+		code = strings.Repeat(string(alphabet[0]), pad) + code
+	}
+	return code
+}
+
+func (g *hashGenerator) Generate(url string, attempt int) (string, error) {
+	sum := sha256.Sum256([]byte(url + "#" + strconv.Itoa(attempt)))
+	code := []rune(encode(binary.BigEndian.Uint64(sum[:8]), g.alphabet))
+
+	if len(code) > g.length {
+		code = code[:g.length]
+	}
+
+	return string(code), nil
+}
+
+func encode(n uint64, alphabet []rune) string {
+	if n == 0 {
+		return string(alphabet[0])
+	}
+
+	base := uint64(len(alphabet))
+	var buf []rune
+	for n > 0 {
+		buf = append(buf, alphabet[n%base])
+		n /= base
+	}
+
+	for i, j := 0, len(buf)-1; i < j; i, j = i+1, j-1 {
+		buf[i], buf[j] = buf[j], buf[i]
+	}
+
+	return string(buf)
+}
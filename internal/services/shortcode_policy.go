@@ -0,0 +1,87 @@
+package services
+
+import (
+	stderrors "errors"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+// ShortcodePolicy decides which shortcodes may be stored. Codes are checked
+// before anything is queued so bad ones never reach the worker.
+type ShortcodePolicy struct {
+	Alphabet      string
+	MinLength     int
+	MaxLength     int // urls.shortcode is VARCHAR(20)
+	CaseSensitive bool
+	Reserved      []string // codes that would shadow current or future routes
+}
+
+func DefaultShortcodePolicy() ShortcodePolicy {
+	return ShortcodePolicy{
+		Alphabet:      base62Alphabet + "-_",
+		MinLength:     3,
+		MaxLength:     20,
+		CaseSensitive: true,
+		Reserved:      []string{"api", "admin", "healthz", "metrics", "stats", "static"},
+	}
+}
+
+// Normalize folds the case of code when the policy is case-insensitive and
+// validates it against the alphabet, length and reserved words.
+func (p ShortcodePolicy) Normalize(code string) (string, error) {
+	code = p.Fold(code)
+
+	err := validation.Validate(code,
+		validation.Required.Error("shortcode is required"),
+		validation.RuneLength(p.MinLength, p.MaxLength).Error("shortcode length is out of bounds"),
+		validation.By(p.checkAlphabet),
+		validation.By(p.checkReserved),
+	)
+	if err != nil {
+		return "", fieldError("shortcode", err)
+	}
+
+	return code, nil
+}
+
+func (p ShortcodePolicy) Fold(code string) string {
+	if p.CaseSensitive {
+		return code
+	}
+	return strings.ToLower(code)
+}
+
+// GeneratorAlphabet returns the characters generated codes are made of: the
+// alphabet once folded, without repeats, so case-insensitive policies don't
+// issue codes that fold onto each other.
+func (p ShortcodePolicy) GeneratorAlphabet() string {
+	var b strings.Builder
+	for _, r := range p.Fold(p.Alphabet) {
+		if !strings.ContainsRune(b.String(), r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func (p ShortcodePolicy) checkAlphabet(value interface{}) error {
+	code, _ := value.(string)
+	alphabet := p.Fold(p.Alphabet) // code is folded already
+	for _, r := range code {
+		if !strings.ContainsRune(alphabet, r) {
+			return stderrors.New("shortcode contains characters outside the allowed alphabet")
+		}
+	}
+	return nil
+}
+
+func (p ShortcodePolicy) checkReserved(value interface{}) error {
+	code, _ := value.(string)
+	for _, word := range p.Reserved {
+		if strings.EqualFold(code, word) {
+			return stderrors.New("shortcode is reserved")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestShortcodePolicyNormalize(t *testing.T) {
+	tests := []struct {
+		name          string
+		caseSensitive bool
+		code          string
+		want          string
+	}{
+		{"keeps case when case sensitive", true, "AbC123", "AbC123"},
+		{"folds case when case insensitive", false, "AbC123", "abc123"},
+		{"accepts dash and underscore", true, "my-link_1", "my-link_1"},
+		{"accepts min length", true, "abc", "abc"},
+		{"accepts max length", true, strings.Repeat("a", 20), strings.Repeat("a", 20)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := DefaultShortcodePolicy()
+			policy.CaseSensitive = tt.caseSensitive
+
+			got, err := policy.Normalize(tt.code)
+			if err != nil {
+				t.Fatalf("Normalize(%q) failed: %v", tt.code, err)
+			}
+			if got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.code, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShortcodePolicyNormalizeRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+	}{
+		{"empty", ""},
+		{"too short", "ab"},
+		{"too long", strings.Repeat("a", 21)},
+		{"outside alphabet", "abc/def"},
+		{"space", "abc def"},
+		{"non ascii", "ação"},
+		{"reserved", "api"},
+		{"reserved in other case", "Admin"},
+	}
+
+	policy := DefaultShortcodePolicy()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := policy.Normalize(tt.code); err == nil {
+				t.Errorf("Normalize(%q) = %q, want an error", tt.code, got)
+			}
+		})
+	}
+}
+
+// Every generator must issue codes the policy accepts, or Store burns its
+// attempts on codes it then rejects.
+func TestGeneratedShortcodesSatisfyPolicy(t *testing.T) {
+	custom := DefaultShortcodePolicy()
+	custom.Alphabet = "abcXYZ"
+	custom.CaseSensitive = false
+
+	for _, policy := range []ShortcodePolicy{DefaultShortcodePolicy(), custom} {
+		alphabet := policy.GeneratorAlphabet()
+		generators := []ShortcodeGenerator{NewRandomGenerator(7, alphabet), NewHashGenerator(7, alphabet)}
+
+		for _, g := range generators {
+			for attempt := range 50 {
+				code, err := g.Generate("https://example.com/", attempt)
+				if err != nil {
+					t.Fatalf("Generate failed: %v", err)
+				}
+				if got, err := policy.Normalize(code); err != nil || got != code {
+					t.Errorf("%T issued %q with alphabet %q, normalized to %q: %v", g, code, alphabet, got, err)
+				}
+			}
+		}
+	}
+}
+
+func TestGeneratorAlphabet(t *testing.T) {
+	policy := DefaultShortcodePolicy()
+	policy.Alphabet = "abcABC-"
+	policy.CaseSensitive = false
+
+	if got := policy.GeneratorAlphabet(); got != "abc-" {
+		t.Errorf("GeneratorAlphabet() = %q, want %q", got, "abc-")
+	}
+}
+
+func TestEncodeSequence(t *testing.T) {
+	policy := DefaultShortcodePolicy()
+	alphabet := []rune(policy.GeneratorAlphabet())
+	seen := make(map[string]uint64)
+
+	for _, n := range []uint64{1, 2, 63, 64, 4095, 4096, 1 << 40, 1<<64 - 1} {
+		code := encodeSequence(n, 7, alphabet)
+		if len(code) < 7 {
+			t.Errorf("encodeSequence(%d) = %q, shorter than 7", n, code)
+		}
+		if _, err := policy.Normalize(code); err != nil {
+			t.Errorf("encodeSequence(%d) = %q, rejected by the policy: %v", n, code, err)
+		}
+		if prev, ok := seen[code]; ok {
+			t.Errorf("encodeSequence(%d) = %q, same as for %d", n, code, prev)
+		}
+		seen[code] = n
+	}
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	stderrors "errors"
+	"net"
+	"net/url"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/rodrigocitadin/url-shortener/internal/errors"
+	"golang.org/x/net/idna"
+)
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// URLPolicy decides which target URLs are accepted and how they are
+// normalized before being stored.
+type URLPolicy struct {
+	AllowedSchemes []string
+	MaxLength      int
+	StripFragment  bool
+	// AllowUserinfo accepts URLs with credentials before the host, such as
+	// https://trusted.example@evil.example, which mostly serve phishing.
+	AllowUserinfo bool
+}
+
+func DefaultURLPolicy() URLPolicy {
+	return URLPolicy{
+		AllowedSchemes: []string{"http", "https"},
+		MaxLength:      2048,
+		StripFragment:  false,
+		AllowUserinfo:  false,
+	}
+}
+
+// Normalize validates raw and returns its canonical form: lowercase scheme
+// and host, punycode host, no default port and, if configured, no fragment.
+func (p URLPolicy) Normalize(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+
+	err := validation.Validate(raw,
+		validation.Required.Error("url is required"),
+		validation.RuneLength(0, p.MaxLength).Error("url is too long"),
+	)
+	if err != nil {
+		return "", fieldError("url", err)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fieldError("url", stderrors.New("url is malformed"))
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if err := validation.Validate(u.Scheme, validation.In(toInterfaces(p.AllowedSchemes)...).Error("url scheme is not allowed")); err != nil {
+		return "", fieldError("url", err)
+	}
+	if u.Scheme == "" || u.Opaque != "" {
+		return "", fieldError("url", stderrors.New("url must be absolute"))
+	}
+	if u.User != nil && !p.AllowUserinfo {
+		return "", fieldError("url", stderrors.New("url must not contain credentials"))
+	}
+
+	host, err := normalizeHost(u.Hostname())
+	if err != nil {
+		return "", fieldError("url", err)
+	}
+
+	port := u.Port()
+	if port == defaultPorts[u.Scheme] {
+		port = ""
+	}
+
+	u.Host = host
+	if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	}
+	if port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	}
+
+	if p.StripFragment {
+		u.Fragment = ""
+		u.RawFragment = ""
+	}
+
+	normalized := u.String()
+	if len(normalized) > p.MaxLength {
+		return "", fieldError("url", stderrors.New("url is too long"))
+	}
+
+	return normalized, nil
+}
+
+func normalizeHost(host string) (string, error) {
+	if host == "" {
+		return "", stderrors.New("url must have a host")
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+
+	ascii, err := idna.Lookup.ToASCII(strings.ToLower(host))
+	if err != nil {
+		return "", stderrors.New("url host is not a valid domain")
+	}
+
+	return ascii, nil
+}
+
+func fieldError(field string, err error) error {
+	return errors.NewValidation("service", map[string]string{field: err.Error()})
+}
+
+func toInterfaces(values []string) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
@@ -1,32 +1,431 @@
 package services
 
 import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
 	"github.com/rodrigocitadin/url-shortener/internal/entities"
+	"github.com/rodrigocitadin/url-shortener/internal/errors"
+	"github.com/rodrigocitadin/url-shortener/internal/messages"
 	"github.com/rodrigocitadin/url-shortener/internal/repository"
 )
 
+const (
+	maxGenerateAttempts = 5
+	topReferrersLimit   = 10
+)
+
+// statsWindows holds, per granularity, the default and the maximum range a
+// stats query may cover.
+var statsWindows = map[string]struct{ def, max time.Duration }{
+	"hour": {24 * time.Hour, 31 * 24 * time.Hour},
+	"day":  {30 * 24 * time.Hour, 366 * 24 * time.Hour},
+}
+
+var redirectTypes = map[int]bool{
+	http.StatusMovedPermanently:  true,
+	http.StatusFound:             true,
+	http.StatusTemporaryRedirect: true,
+	http.StatusPermanentRedirect: true,
+}
+
+type StoreInput struct {
+	URL          string
+	Shortcode    string
+	RedirectType int // 0 uses the server default
+	ExpiresAt    *time.Time
+	MaxClicks    int64  // 0 means unlimited
+	RequestID    string // carried by queued writes for tracing
+}
+
+// UpdateInput changes an existing link. Nil fields are kept, unless Replace
+// is set, in which case they are reset like on a fresh Store.
+type UpdateInput struct {
+	Shortcode    string
+	URL          *string
+	RedirectType *int
+	ExpiresAt    *time.Time
+	MaxClicks    *int64
+	Replace      bool
+	RequestID    string
+}
+
+type StatsQuery struct {
+	From        time.Time
+	To          time.Time
+	Granularity string // hour, day
+}
+
+type URLStats struct {
+	Shortcode    string
+	URL          string
+	Accesses     int64
+	From         time.Time
+	To           time.Time
+	Granularity  string
+	Series       []repository.ClickBucket
+	TopReferrers []repository.ReferrerCount
+}
+
 type URLService interface {
 	Get(shortcode string) (*entities.URLEntity, error)
-	Store(url, shortcode string) error
+	Stats(shortcode string, query StatsQuery) (*URLStats, error)
+	TrackClick(event *entities.ClickEvent)
+	Store(input StoreInput) (*entities.URLEntity, repository.WriteStatus, error)
+	Update(input UpdateInput) (*entities.URLEntity, repository.WriteStatus, error)
+	Delete(shortcode, requestID string) (repository.WriteStatus, error)
+}
+
+type URLServiceConfig struct {
+	Generator           ShortcodeGenerator
+	URLPolicy           URLPolicy
+	ShortcodePolicy     ShortcodePolicy
+	DefaultRedirectType int
+	// Outbox writes links synchronously to their shard together with an
+	// outbox row, instead of publishing them to the queue.
+	Outbox bool
 }
 
 type urlService struct {
-	uow repository.UnitOfWork
+	uow    repository.UnitOfWork
+	config URLServiceConfig
 }
 
 func (u *urlService) Get(shortcode string) (*entities.URLEntity, error) {
+	shortcode = u.config.ShortcodePolicy.Fold(shortcode)
 	r := u.uow.URLS(shortcode)
-	return r.Find(shortcode)
+
+	entity, err := r.Find(shortcode)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending int64
+	if entity.MaxClicks > 0 {
+		pending, err = u.uow.Accesses().Pending(shortcode)
+		if err != nil {
+			return nil, errors.NewInternal("service", err)
+		}
+	}
+
+	if entity.Expired(time.Now(), entity.Accesses+pending) {
+		return nil, errors.NewGone("link has expired", "service", nil)
+	}
+
+	if entity.RedirectType == 0 {
+		entity.RedirectType = u.config.DefaultRedirectType
+	}
+
+	if err := u.uow.Accesses().Increment(shortcode); err != nil {
+		log.Printf("failed to count access for %s: %v", shortcode, err)
+	}
+
+	return entity, nil
 }
 
-func (u *urlService) Store(url string, shortcode string) error {
-	r := u.uow.URLS(shortcode)
-	return r.Save(&entities.URLEntity{
-		URL:       url,
-		Shortcode: shortcode,
+// TrackClick publishes the click for analytics without blocking the redirect.
+func (u *urlService) TrackClick(event *entities.ClickEvent) {
+	event.Shortcode = u.config.ShortcodePolicy.Fold(event.Shortcode)
+
+	go func() {
+		if err := u.uow.Clicks().Publish(event); err != nil {
+			log.Printf("failed to publish click for %s: %v", event.Shortcode, err)
+		}
+	}()
+}
+
+// Stats reports the accesses already flushed to the shard plus the ones
+// still buffered in Redis, and the clicks in the requested window taken from
+// the hourly rollups of the shard owning the shortcode.
+func (u *urlService) Stats(shortcode string, query StatsQuery) (*URLStats, error) {
+	shortcode = u.config.ShortcodePolicy.Fold(shortcode)
+
+	query, err := normalizeStatsQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	entity, err := u.uow.URLS(shortcode).Find(shortcode)
+	if err != nil {
+		return nil, err
+	}
+
+	pending, err := u.uow.Accesses().Pending(shortcode)
+	if err != nil {
+		return nil, errors.NewInternal("service", err)
+	}
+
+	rollups := u.uow.ClickRollups(shortcode)
+
+	series, err := rollups.Series(shortcode, query.From, query.To, query.Granularity)
+	if err != nil {
+		return nil, errors.NewInternal("service", err)
+	}
+
+	referrers, err := rollups.TopReferrers(shortcode, query.From, query.To, topReferrersLimit)
+	if err != nil {
+		return nil, errors.NewInternal("service", err)
+	}
+
+	return &URLStats{
+		Shortcode:    entity.Shortcode,
+		URL:          entity.URL,
+		Accesses:     entity.Accesses + pending,
+		From:         query.From,
+		To:           query.To,
+		Granularity:  query.Granularity,
+		Series:       series,
+		TopReferrers: referrers,
+	}, nil
+}
+
+func normalizeStatsQuery(query StatsQuery) (StatsQuery, error) {
+	if query.Granularity == "" {
+		query.Granularity = "hour"
+	}
+
+	window, ok := statsWindows[query.Granularity]
+	if !ok {
+		return query, errors.NewValidation("service", map[string]string{
+			"granularity": "granularity must be hour or day",
+		})
+	}
+
+	if query.To.IsZero() {
+		query.To = time.Now().UTC()
+	}
+	if query.From.IsZero() {
+		query.From = query.To.Add(-window.def)
+	}
+
+	if !query.From.Before(query.To) {
+		return query, errors.NewValidation("service", map[string]string{
+			"from": "from must be before to",
+		})
+	}
+	if query.To.Sub(query.From) > window.max {
+		return query, errors.NewValidation("service", map[string]string{
+			"from": fmt.Sprintf("range is too large for %s granularity", query.Granularity),
+		})
+	}
+
+	return query, nil
+}
+
+func (u *urlService) Store(input StoreInput) (*entities.URLEntity, repository.WriteStatus, error) {
+	url, err := u.config.URLPolicy.Normalize(input.URL)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := validateLimits(input.RedirectType, input.ExpiresAt, input.MaxClicks); err != nil {
+		return nil, "", err
+	}
+
+	shortcode := input.Shortcode
+	if shortcode == "" {
+		code, err := u.generateShortcode(url)
+		if err != nil {
+			return nil, "", err
+		}
+		shortcode = code
+	} else {
+		shortcode, err = u.config.ShortcodePolicy.Normalize(shortcode)
+		if err != nil {
+			return nil, "", err
+		}
+
+		// queued writes are only checked by the worker, long after the
+		// pending record would have served this URL under someone else's code
+		exists, err := u.uow.URLS(shortcode).Exists(shortcode)
+		if err != nil {
+			return nil, "", errors.NewInternal("service", err)
+		}
+		if exists {
+			return nil, "", errors.NewConflict("shortcode already taken", "service", nil)
+		}
+	}
+
+	entity := &entities.URLEntity{
+		URL:          url,
+		Shortcode:    shortcode,
+		RedirectType: input.RedirectType,
+		ExpiresAt:    input.ExpiresAt,
+		MaxClicks:    input.MaxClicks,
+	}
+
+	payload := &messages.URLPayload{URL: entity}
+	status, err := u.write(shortcode, input.RequestID, messages.OperationCreate, payload, func(r repository.URLRepository) (repository.WriteStatus, error) {
+		return r.Save(entity)
 	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return entity, status, nil
+}
+
+func (u *urlService) Update(input UpdateInput) (*entities.URLEntity, repository.WriteStatus, error) {
+	shortcode := u.config.ShortcodePolicy.Fold(input.Shortcode)
+
+	entity, err := u.uow.URLS(shortcode).Find(shortcode)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var columns []string
+
+	if input.URL != nil || input.Replace {
+		raw := ""
+		if input.URL != nil {
+			raw = *input.URL
+		}
+		url, err := u.config.URLPolicy.Normalize(raw)
+		if err != nil {
+			return nil, "", err
+		}
+		entity.URL = url
+		columns = append(columns, "url")
+	}
+
+	if input.RedirectType != nil || input.Replace {
+		entity.RedirectType = 0
+		if input.RedirectType != nil {
+			entity.RedirectType = *input.RedirectType
+		}
+		columns = append(columns, "redirect_type")
+	}
+
+	if input.ExpiresAt != nil || input.Replace {
+		entity.ExpiresAt = input.ExpiresAt
+		columns = append(columns, "expires_at")
+	}
+
+	if input.MaxClicks != nil || input.Replace {
+		entity.MaxClicks = 0
+		if input.MaxClicks != nil {
+			entity.MaxClicks = *input.MaxClicks
+		}
+		columns = append(columns, "max_clicks")
+	}
+
+	if len(columns) == 0 {
+		return nil, "", errors.NewBadRequest("nothing to update", "service", nil)
+	}
+
+	if err := validateLimits(entity.RedirectType, input.ExpiresAt, entity.MaxClicks); err != nil {
+		return nil, "", err
+	}
+
+	payload := &messages.URLPayload{URL: entity, Columns: columns}
+	status, err := u.write(shortcode, input.RequestID, messages.OperationUpdate, payload, func(r repository.URLRepository) (repository.WriteStatus, error) {
+		return r.Update(entity, columns)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return entity, status, nil
+}
+
+func (u *urlService) Delete(shortcode, requestID string) (repository.WriteStatus, error) {
+	shortcode = u.config.ShortcodePolicy.Fold(shortcode)
+
+	if _, err := u.uow.URLS(shortcode).Find(shortcode); err != nil {
+		return "", err
+	}
+
+	payload := &messages.URLPayload{URL: &entities.URLEntity{Shortcode: shortcode}}
+	return u.write(shortcode, requestID, messages.OperationDelete, payload, func(r repository.URLRepository) (repository.WriteStatus, error) {
+		return r.Delete(shortcode)
+	})
+}
+
+// write applies fn to the links repository of shortcode. By default that
+// repository queues the write; in outbox mode fn runs in a shard transaction
+// that also records the message for the worker relay, so the link and its
+// message are committed together.
+func (u *urlService) write(shortcode, requestID, operation string, payload *messages.URLPayload, fn func(repository.URLRepository) (repository.WriteStatus, error)) (repository.WriteStatus, error) {
+	if !u.config.Outbox {
+		return fn(u.uow.WithRequestID(requestID).URLS(shortcode))
+	}
+
+	var status repository.WriteStatus
+	err := u.uow.ExecuteTx(shortcode, func(f repository.Factory) error {
+		var err error
+		status, err = fn(f.URLS(shortcode))
+		if err != nil {
+			return err
+		}
+
+		env, err := messages.New(operation, requestID, payload)
+		if err != nil {
+			return err
+		}
+		return f.Outbox().Add(env, shortcode)
+	})
+	return status, err
+}
+
+// validateLimits checks the redirect type and the expiration settings of a
+// link being stored or updated.
+func validateLimits(redirectType int, expiresAt *time.Time, maxClicks int64) error {
+	fields := map[string]string{}
+
+	if redirectType != 0 && !redirectTypes[redirectType] {
+		fields["redirect_type"] = "redirect_type must be 301, 302, 307 or 308"
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		fields["expires_at"] = "expires_at must be in the future"
+	}
+	if maxClicks < 0 {
+		fields["max_clicks"] = "max_clicks must not be negative"
+	}
+
+	if len(fields) > 0 {
+		return errors.NewValidation("service", fields)
+	}
+	return nil
+}
+
+// generateShortcode asks the generator for a code until it finds one that
+// satisfies the shortcode policy and is free on the shard the code would be
+// placed on.
+func (u *urlService) generateShortcode(url string) (string, error) {
+	for attempt := range maxGenerateAttempts {
+		code, err := u.config.Generator.Generate(url, attempt)
+		if err != nil {
+			return "", errors.NewInternal("service", err)
+		}
+
+		code, err = u.config.ShortcodePolicy.Normalize(code)
+		if err != nil {
+			continue
+		}
+
+		exists, err := u.uow.URLS(code).Exists(code)
+		if err != nil {
+			return "", errors.NewInternal("service", err)
+		}
+		if !exists {
+			return code, nil
+		}
+	}
+
+	return "", errors.NewInternal("service",
+		fmt.Errorf("could not generate a free shortcode after %d attempts", maxGenerateAttempts))
+}
+
+func NewURLService(uow repository.UnitOfWork, config URLServiceConfig) URLService {
+	if config.DefaultRedirectType == 0 {
+		config.DefaultRedirectType = http.StatusMovedPermanently
+	}
+	return &urlService{uow: uow, config: config}
 }
 
-func NewURLService(uow repository.UnitOfWork) URLService {
-	return &urlService{uow: uow}
+// IsRedirectType reports whether code is a redirect status a link may use.
+func IsRedirectType(code int) bool {
+	return redirectTypes[code]
 }
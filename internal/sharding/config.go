@@ -0,0 +1,162 @@
+package sharding
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PlacementModulo is fnv32a(key) % N. Adding a shard remaps almost every
+	// key, so it only suits a fixed shard count; it stays the default because
+	// existing clusters were filled with it.
+	PlacementModulo = "modulo"
+	// PlacementRing uses a consistent-hash Ring over the shard IDs.
+	PlacementRing = "ring"
+
+	DefaultVirtualNodes = 160
+)
+
+// ShardResolver decides which shard owns a key. Everything that routes a
+// key to a shard must go through one.
+type ShardResolver interface {
+	// Resolve returns the index of the shard owning key.
+	Resolve(key string) int
+}
+
+type Config struct {
+	IDs          []string
+	DSNs         []string
+	Placement    string
+	VirtualNodes int
+
+	// Directory enables the prefix directory on top of Placement; keys no
+	// directory entry matches are still placed by Placement.
+	Directory bool
+	// DirectoryShard is the ID of the shard holding the directory table.
+	DirectoryShard string
+
+	// Previous is the topology being migrated away from, set while rows are
+	// moved by cmd/reshard. Reads that miss fall back to it.
+	Previous *Config
+}
+
+// ConfigFromEnv reads SHARD_DSNS plus the optional SHARD_IDS, SHARD_PLACEMENT,
+// SHARD_VNODES, SHARD_DIRECTORY and SHARD_DIRECTORY_SHARD. Without SHARD_IDS
+// shards are named after their position, which keeps working as long as new
+// DSNs are only ever appended. The same
+// variables prefixed with OLD_ describe the previous topology during a
+// migration.
+func ConfigFromEnv() (Config, error) {
+	cfg, err := configFromEnv("")
+	if err != nil {
+		return cfg, err
+	}
+
+	if os.Getenv("OLD_SHARD_DSNS") != "" {
+		previous, err := configFromEnv("OLD_")
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Previous = &previous
+	}
+	return cfg, nil
+}
+
+func configFromEnv(prefix string) (Config, error) {
+	cfg := Config{
+		DSNs:         splitList(os.Getenv(prefix + "SHARD_DSNS")),
+		IDs:          splitList(os.Getenv(prefix + "SHARD_IDS")),
+		Placement:    os.Getenv(prefix + "SHARD_PLACEMENT"),
+		VirtualNodes: DefaultVirtualNodes,
+	}
+
+	if v := os.Getenv(prefix + "SHARD_VNODES"); v != "" {
+		num, err := strconv.Atoi(v)
+		if err != nil || num <= 0 {
+			return cfg, fmt.Errorf("invalid %sSHARD_VNODES %q", prefix, v)
+		}
+		cfg.VirtualNodes = num
+	}
+
+	if v := os.Getenv(prefix + "SHARD_DIRECTORY"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid %sSHARD_DIRECTORY %q", prefix, v)
+		}
+		cfg.Directory = enabled
+		cfg.DirectoryShard = os.Getenv(prefix + "SHARD_DIRECTORY_SHARD")
+	}
+
+	if err := cfg.normalize(); err != nil {
+		return cfg, fmt.Errorf("%sSHARD_*: %w", prefix, err)
+	}
+	return cfg, nil
+}
+
+func (c *Config) normalize() error {
+	if len(c.DSNs) == 0 {
+		return errors.New("no DSNs defined")
+	}
+
+	if len(c.IDs) == 0 {
+		for i := range c.DSNs {
+			c.IDs = append(c.IDs, strconv.Itoa(i))
+		}
+	}
+	if len(c.IDs) != len(c.DSNs) {
+		return fmt.Errorf("%d ids for %d DSNs", len(c.IDs), len(c.DSNs))
+	}
+
+	seen := make(map[string]bool, len(c.IDs))
+	for _, id := range c.IDs {
+		if seen[id] {
+			return fmt.Errorf("duplicated shard id %q", id)
+		}
+		seen[id] = true
+	}
+
+	if c.Directory && !seen[c.DirectoryShard] {
+		return fmt.Errorf("directory shard %q is not one of the shard ids", c.DirectoryShard)
+	}
+
+	if c.Placement == "" {
+		c.Placement = PlacementModulo
+	}
+	return nil
+}
+
+// NewResolver builds the hash resolver the config asks for. The directory,
+// which needs Redis and a database, is layered on top by the caller.
+func (c Config) NewResolver() (ShardResolver, error) {
+	switch c.Placement {
+	case PlacementModulo:
+		return Modulo(len(c.IDs)), nil
+	case PlacementRing:
+		return NewRing(c.IDs, c.VirtualNodes), nil
+	default:
+		return nil, fmt.Errorf("unknown placement %q", c.Placement)
+	}
+}
+
+// Modulo is the original placement, fnv32a(key) % n.
+type Modulo int
+
+func (n Modulo) Resolve(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+func splitList(v string) []string {
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
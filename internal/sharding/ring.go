@@ -0,0 +1,65 @@
+package sharding
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// Ring is a consistent-hash ring. Every shard owns vnodes points on it and a
+// key belongs to the first point at or after its hash, so adding a shard
+// moves only about 1/N of the keys, all of them to the new shard.
+type Ring struct {
+	points []ringPoint
+}
+
+type ringPoint struct {
+	hash  uint64
+	shard int
+}
+
+// NewRing places the shards on the ring by their IDs, never their position,
+// so reordering SHARD_DSNS doesn't move any key.
+func NewRing(ids []string, vnodes int) *Ring {
+	points := make([]ringPoint, 0, len(ids)*vnodes)
+	for idx, id := range ids {
+		for v := range vnodes {
+			points = append(points, ringPoint{hash: hash64(id + "#" + strconv.Itoa(v)), shard: idx})
+		}
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash == points[j].hash {
+			return points[i].shard < points[j].shard
+		}
+		return points[i].hash < points[j].hash
+	})
+
+	return &Ring{points: points}
+}
+
+func (r *Ring) Resolve(key string) int {
+	h := hash64(key)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= h
+	})
+	if i == len(r.points) {
+		i = 0 // wrap around
+	}
+	return r.points[i].shard
+}
+
+// hash64 is fnv64a followed by the murmur3 finalizer; fnv alone clusters the
+// short, similar vnode names on a few arcs of the ring.
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
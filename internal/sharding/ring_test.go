@@ -0,0 +1,71 @@
+package sharding
+
+import (
+	"fmt"
+	"testing"
+)
+
+const ringTestKeys = 100000
+
+func ringKeys() []string {
+	keys := make([]string, ringTestKeys)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("code%d", i)
+	}
+	return keys
+}
+
+func TestRingAddShardMovesOnlyToNewShard(t *testing.T) {
+	before := NewRing([]string{"a", "b", "c"}, DefaultVirtualNodes)
+	after := NewRing([]string{"a", "b", "c", "d"}, DefaultVirtualNodes)
+
+	moved := 0
+	for _, key := range ringKeys() {
+		from, to := before.Resolve(key), after.Resolve(key)
+		if from == to {
+			continue
+		}
+		if to != 3 {
+			t.Fatalf("key %s moved from shard %d to %d, not to the new shard", key, from, to)
+		}
+		moved++
+	}
+
+	// the new shard should take about a quarter of the keys
+	share := float64(moved) / ringTestKeys
+	if share < 0.18 || share > 0.32 {
+		t.Errorf("new shard took %.1f%% of the keys, want about 25%%", share*100)
+	}
+}
+
+func TestRingIgnoresIDOrder(t *testing.T) {
+	ids := []string{"a", "b", "c", "d"}
+	reordered := []string{"c", "a", "d", "b"}
+
+	ring := NewRing(ids, DefaultVirtualNodes)
+	other := NewRing(reordered, DefaultVirtualNodes)
+
+	for _, key := range ringKeys() {
+		want := ids[ring.Resolve(key)]
+		if got := reordered[other.Resolve(key)]; got != want {
+			t.Fatalf("key %s placed on %s after reordering, was %s", key, got, want)
+		}
+	}
+}
+
+func TestRingBalance(t *testing.T) {
+	ids := []string{"a", "b", "c", "d"}
+	ring := NewRing(ids, DefaultVirtualNodes)
+
+	counts := make([]int, len(ids))
+	for _, key := range ringKeys() {
+		counts[ring.Resolve(key)]++
+	}
+
+	for idx, n := range counts {
+		share := float64(n) / ringTestKeys
+		if share < 0.18 || share > 0.32 {
+			t.Errorf("shard %s holds %.1f%% of the keys, want about 25%%", ids[idx], share*100)
+		}
+	}
+}
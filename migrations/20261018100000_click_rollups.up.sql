@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS url_clicks_hourly (
+    shortcode VARCHAR(20) NOT NULL,
+    bucket TIMESTAMPTZ NOT NULL,
+    referrer TEXT NOT NULL DEFAULT '',
+    country VARCHAR(2) NOT NULL DEFAULT '',
+    device VARCHAR(16) NOT NULL DEFAULT '',
+    clicks BIGINT NOT NULL DEFAULT 0 CHECK (clicks >= 0),
+    PRIMARY KEY (shortcode, bucket, referrer, country, device)
+);
@@ -0,0 +1,3 @@
+-- 0 means the link follows the server-wide default
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS redirect_type SMALLINT NOT NULL DEFAULT 0
+    CHECK (redirect_type IN (0, 301, 302, 307, 308));
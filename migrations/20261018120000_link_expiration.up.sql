@@ -0,0 +1,3 @@
+ALTER TABLE urls
+    ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ NULL,
+    ADD COLUMN IF NOT EXISTS max_clicks BIGINT NOT NULL DEFAULT 0 CHECK (max_clicks >= 0); -- 0 means unlimited
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS urls_expires_at_idx;
+DROP TABLE IF EXISTS urls_archive;
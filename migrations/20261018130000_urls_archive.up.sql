@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS urls_archive (
+    id BIGINT PRIMARY KEY,
+    shortcode VARCHAR(20) NOT NULL,
+    url TEXT NOT NULL,
+    accesses BIGINT NOT NULL DEFAULT 0,
+    redirect_type SMALLINT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMPTZ NULL,
+    max_clicks BIGINT NOT NULL DEFAULT 0,
+    archived_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS urls_expires_at_idx ON urls (expires_at) WHERE expires_at IS NOT NULL;
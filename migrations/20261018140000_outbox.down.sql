@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS outbox_unsent_idx;
+DROP TABLE IF EXISTS outbox;
@@ -0,0 +1,7 @@
+-- Only the table on SHARD_DIRECTORY_SHARD is read; the others stay empty.
+-- Change it with cmd/directory, which announces the change to every process.
+CREATE TABLE IF NOT EXISTS shard_directory (
+    prefix VARCHAR(20) PRIMARY KEY, -- shortcodes starting with it are pinned
+    shard_id TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS urls_exhausted_at_idx;
+ALTER TABLE urls_archive DROP COLUMN IF EXISTS exhausted_at;
+ALTER TABLE urls DROP COLUMN IF EXISTS exhausted_at;
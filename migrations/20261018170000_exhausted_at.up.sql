@@ -0,0 +1,7 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS exhausted_at TIMESTAMPTZ NULL; -- when accesses reached max_clicks
+ALTER TABLE urls_archive ADD COLUMN IF NOT EXISTS exhausted_at TIMESTAMPTZ NULL;
+
+-- links already exhausted start their grace period now
+UPDATE urls SET exhausted_at = now() WHERE max_clicks > 0 AND accesses >= max_clicks AND exhausted_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS urls_exhausted_at_idx ON urls (exhausted_at) WHERE exhausted_at IS NOT NULL;